@@ -0,0 +1,298 @@
+package dataloaden
+
+import "container/list"
+
+// Cache is the storage a Loader keeps its values in.
+// A Cache may be shared by many loaders, and a Loader calls Get concurrently,
+// so implementations must be safe for concurrent use.
+type Cache[K comparable, V any] interface {
+	// Get returns the value at key and whether it was found
+	Get(key K) (V, bool)
+
+	// Set stores value at key, overwriting any existing value
+	Set(key K, value V)
+
+	// Delete removes the value at key, if it exists
+	Delete(key K)
+
+	// Clear removes every value
+	Clear()
+
+	// Range calls f for every value until f returns false. f must not modify the cache.
+	Range(f func(key K, value V) bool)
+}
+
+// storingCache is implemented by the caches of this package whose Set may not store the value
+type storingCache[K comparable, V any] interface {
+	// set is Set, also reporting whether value was stored
+	set(key K, value V) bool
+}
+
+type cacheEntry[K comparable, V any] struct {
+	key   K
+	value V
+
+	// the SizeOf value, only tracked by a boundedCache with maxBytes
+	size int64
+}
+
+// mapCache is the default Cache. it is only used by a single Loader, which guards it with its mutex:
+// Get is called holding a read lock, and the other methods holding a write lock.
+type mapCache[K comparable, V any] struct {
+	// lazily created
+	m map[K]V
+
+	// initial capacity of m
+	capacity int
+}
+
+func (c *mapCache[K, V]) Get(key K) (V, bool) {
+	v, ok := c.m[key]
+	return v, ok
+}
+
+func (c *mapCache[K, V]) Set(key K, value V) {
+	if c.m == nil {
+		c.m = make(map[K]V, c.capacity)
+	}
+	c.m[key] = value
+}
+
+func (c *mapCache[K, V]) Delete(key K) {
+	delete(c.m, key)
+}
+
+func (c *mapCache[K, V]) Clear() {
+	c.m = nil
+}
+
+func (c *mapCache[K, V]) Range(f func(key K, value V) bool) {
+	for k, v := range c.m {
+		if !f(k, v) {
+			return
+		}
+	}
+}
+
+// EvictionPolicy is how the in-memory cache makes room when it holds LoaderConfig.MaxCacheSize values
+type EvictionPolicy int
+
+const (
+	// EvictLRU evicts the least recently used value. It is the default.
+	EvictLRU EvictionPolicy = iota
+
+	// EvictFIFO evicts the value that was cached first. It is cheaper than EvictLRU,
+	// since loads don't need to track when values are used.
+	EvictFIFO
+
+	// EvictNone never evicts: once the cache is full, new values are not cached
+	// until some are cleared or expire, and a value updated to one that no longer fits is removed.
+	EvictNone
+)
+
+// boundedCache is a Cache holding at most max values, and values of at most maxBytes in total,
+// making room as its policy says. like mapCache it is guarded by the loader's mutex.
+type boundedCache[K comparable, V any] struct {
+	// 0 = no limit
+	max int
+
+	// the most the sizeOf all values may add up to, 0 = no limit
+	maxBytes int64
+	sizeOf   func(V) int64
+
+	// the sizeOf all values
+	bytes int64
+
+	// initial capacity of items
+	capacity int
+
+	policy EvictionPolicy
+
+	// most recently used (LRU) or most recently added (FIFO) entries are at the front
+	ll    *list.List
+	items map[K]*list.Element
+
+	// called for every evicted entry
+	onEvict func(key K, value V)
+}
+
+func newBoundedCache[K comparable, V any](max, capacity int, policy EvictionPolicy) *boundedCache[K, V] {
+	if max > 0 && capacity > max {
+		capacity = max
+	}
+	return &boundedCache[K, V]{
+		max:      max,
+		capacity: capacity,
+		policy:   policy,
+		ll:       list.New(),
+		items:    make(map[K]*list.Element, capacity),
+	}
+}
+
+// concurrentGet reports whether Get may be called concurrently, which it can unless it tracks use
+func (c *boundedCache[K, V]) concurrentGet() bool {
+	return c.policy != EvictLRU
+}
+
+func (c *boundedCache[K, V]) Get(key K) (V, bool) {
+	e, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	if c.policy == EvictLRU {
+		c.ll.MoveToFront(e)
+	}
+	return e.Value.(*cacheEntry[K, V]).value, true
+}
+
+func (c *boundedCache[K, V]) Set(key K, value V) {
+	c.set(key, value)
+}
+
+// set is Set, also reporting whether value was stored: it isn't when it would never fit,
+// when the policy is EvictNone and there is no room for it, or when making room evicted it
+func (c *boundedCache[K, V]) set(key K, value V) bool {
+	var size int64
+	if c.maxBytes > 0 {
+		size = c.sizeOf(value)
+		if size > c.maxBytes {
+			// it would never fit, so don't leave an older value behind either
+			c.remove(key)
+			return false
+		}
+	}
+	if e, ok := c.items[key]; ok {
+		entry := e.Value.(*cacheEntry[K, V])
+		if c.policy == EvictNone && c.maxBytes > 0 && c.bytes+size-entry.size > c.maxBytes {
+			// making room would evict other values, and the older value is stale
+			c.remove(key)
+			return false
+		}
+		if c.policy == EvictLRU {
+			c.ll.MoveToFront(e)
+		}
+		c.bytes += size - entry.size
+		entry.value, entry.size = value, size
+		c.evict()
+		_, ok = c.items[key]
+		return ok
+	}
+	if c.policy == EvictNone && c.full(size) {
+		return false
+	}
+	c.items[key] = c.ll.PushFront(&cacheEntry[K, V]{key: key, value: value, size: size})
+	c.bytes += size
+	c.evict()
+	_, ok := c.items[key]
+	return ok
+}
+
+// full reports whether there is no room for one more value of size
+func (c *boundedCache[K, V]) full(size int64) bool {
+	return c.max > 0 && c.ll.Len() >= c.max || c.maxBytes > 0 && c.bytes+size > c.maxBytes
+}
+
+// evict removes the values at the back until the cache is within its limits
+func (c *boundedCache[K, V]) evict() {
+	for c.max > 0 && c.ll.Len() > c.max || c.maxBytes > 0 && c.bytes > c.maxBytes {
+		oldest := c.ll.Back()
+		entry := oldest.Value.(*cacheEntry[K, V])
+		c.ll.Remove(oldest)
+		delete(c.items, entry.key)
+		c.bytes -= entry.size
+		if c.onEvict != nil {
+			c.onEvict(entry.key, entry.value)
+		}
+	}
+}
+
+func (c *boundedCache[K, V]) Delete(key K) {
+	if e, ok := c.items[key]; ok {
+		c.ll.Remove(e)
+		delete(c.items, key)
+		c.bytes -= e.Value.(*cacheEntry[K, V]).size
+	}
+}
+
+// remove deletes the value at key like Delete, but as an eviction, passing it to onEvict
+func (c *boundedCache[K, V]) remove(key K) {
+	e, ok := c.items[key]
+	if !ok {
+		return
+	}
+	c.Delete(key)
+	if c.onEvict != nil {
+		c.onEvict(key, e.Value.(*cacheEntry[K, V]).value)
+	}
+}
+
+func (c *boundedCache[K, V]) Clear() {
+	c.ll.Init()
+	c.items = make(map[K]*list.Element, c.capacity)
+	c.bytes = 0
+}
+
+func (c *boundedCache[K, V]) Range(f func(key K, value V) bool) {
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*cacheEntry[K, V])
+		if !f(entry.key, entry.value) {
+			return
+		}
+	}
+}
+
+// codecCache is a Cache storing values encoded as bytes in another Cache, see LoaderConfig.ByteCache.
+// values that fail to encode aren't stored, and values that fail to decode are reported as missing.
+type codecCache[K comparable, V any] struct {
+	store     Cache[K, []byte]
+	marshal   func(V) ([]byte, error)
+	unmarshal func([]byte) (V, error)
+}
+
+func (c *codecCache[K, V]) Get(key K) (V, bool) {
+	var zero V
+	b, ok := c.store.Get(key)
+	if !ok {
+		return zero, false
+	}
+	v, err := c.unmarshal(b)
+	if err != nil {
+		return zero, false
+	}
+	return v, true
+}
+
+func (c *codecCache[K, V]) Set(key K, value V) {
+	c.set(key, value)
+}
+
+// set is Set, also reporting whether value was stored, which it isn't if it fails to encode
+func (c *codecCache[K, V]) set(key K, value V) bool {
+	b, err := c.marshal(value)
+	if err != nil {
+		// don't leave an older value behind
+		c.store.Delete(key)
+		return false
+	}
+	c.store.Set(key, b)
+	return true
+}
+
+func (c *codecCache[K, V]) Delete(key K) {
+	c.store.Delete(key)
+}
+
+func (c *codecCache[K, V]) Clear() {
+	c.store.Clear()
+}
+
+func (c *codecCache[K, V]) Range(f func(key K, value V) bool) {
+	c.store.Range(func(key K, b []byte) bool {
+		v, err := c.unmarshal(b)
+		if err != nil {
+			return true
+		}
+		return f(key, v)
+	})
+}
@@ -0,0 +1,614 @@
+package dataloaden_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+type syncMapCache[K comparable, V any] struct {
+	m sync.Map
+}
+
+func (c *syncMapCache[K, V]) Get(key K) (V, bool) {
+	v, ok := c.m.Load(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return v.(V), true
+}
+
+func (c *syncMapCache[K, V]) Set(key K, value V) { c.m.Store(key, value) }
+func (c *syncMapCache[K, V]) Delete(key K)       { c.m.Delete(key) }
+func (c *syncMapCache[K, V]) Clear()             { c.m.Range(func(k, _ any) bool { c.m.Delete(k); return true }) }
+func (c *syncMapCache[K, V]) Range(f func(key K, value V) bool) {
+	c.m.Range(func(k, v any) bool { return f(k.(K), v.(V)) })
+}
+
+func TestLoader_Cache(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	cache := &syncMapCache[int, int]{}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+		Cache: cache,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	cache.Set(1, 1000)
+	if got, _ := loader.Load(1); got != 1000 {
+		t.Errorf("Load() got = %v, want %v", got, 1000)
+	}
+
+	if _, err := loader.Load(2); err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+	if got, ok := cache.Get(2); !ok || got != 20 {
+		t.Errorf("Cache.Get() got = %v, %v, want %v, %v", got, ok, 20, true)
+	}
+
+	loader.Clear(2)
+	if _, ok := cache.Get(2); ok {
+		t.Errorf("Cache.Get() found cleared key")
+	}
+
+	loader.ClearAll()
+	if _, ok := cache.Get(1); ok {
+		t.Errorf("Cache.Get() found key after ClearAll")
+	}
+}
+
+func TestLoader_MaxCacheSize(t *testing.T) {
+	var fetched []int
+	fetch := func(keys []int) ([]int, []error) {
+		fetched = append(fetched, keys...)
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:        fetch,
+		Wait:         1 * time.Millisecond,
+		MaxCacheSize: 2,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.Prime(1, 10)
+	loader.Prime(2, 20)
+	if got, _ := loader.Load(1); got != 10 {
+		t.Errorf("Load() got = %v, want %v", got, 10)
+	}
+	loader.Prime(3, 30)
+
+	if got, _ := loader.Load(1); got != 10 {
+		t.Errorf("Load() got = %v, want %v", got, 10)
+	}
+	if got, _ := loader.Load(3); got != 30 {
+		t.Errorf("Load() got = %v, want %v", got, 30)
+	}
+	if len(fetched) != 0 {
+		t.Errorf("fetched %v, want nothing", fetched)
+	}
+
+	loader.Load(2)
+	if want := []int{2}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_EvictionPolicy(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	tests := []struct {
+		name   string
+		policy dataloaden.EvictionPolicy
+		want   []int
+	}{
+		{name: "lru", policy: dataloaden.EvictLRU, want: []int{1, 3}},
+		{name: "fifo", policy: dataloaden.EvictFIFO, want: []int{2, 3}},
+		{name: "none", policy: dataloaden.EvictNone, want: []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+				Fetch:          fetch,
+				Wait:           1 * time.Millisecond,
+				MaxCacheSize:   2,
+				EvictionPolicy: tt.policy,
+			})
+			loader.Prime(1, 10)
+			loader.Prime(2, 20)
+			// only LRU cares that 1 is used after 2
+			loader.Load(1)
+			loader.Prime(3, 30)
+
+			got := loader.Keys()
+			sort.Ints(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Keys() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoader_MaxCacheBytes(t *testing.T) {
+	fetch := func(keys []string) ([]string, []error) {
+		return make([]string, len(keys)), nil
+	}
+	var evicted []string
+	loader := dataloaden.NewLoader(dataloaden.LoaderConfig[string, string]{
+		Fetch:         fetch,
+		Wait:          1 * time.Millisecond,
+		MaxCacheBytes: 10,
+		SizeOf:        func(value string) int64 { return int64(len(value)) },
+		OnEvict:       func(key string, _ string) { evicted = append(evicted, key) },
+	})
+	keys := func() []string {
+		got := loader.Keys()
+		sort.Strings(got)
+		return got
+	}
+
+	loader.Prime("a", "aaaa")
+	loader.Prime("b", "bbbb")
+	loader.Load("a")
+	// 4+4+3 bytes don't fit, so the least recently used b makes room
+	loader.Prime("c", "ccc")
+	if want := []string{"a", "c"}; !reflect.DeepEqual(keys(), want) {
+		t.Errorf("Keys() got = %v, want %v", keys(), want)
+	}
+
+	// too big to ever fit
+	loader.Prime("d", "ddddddddddd")
+	if want := []string{"a", "c"}; !reflect.DeepEqual(keys(), want) {
+		t.Errorf("Keys() got = %v, want %v", keys(), want)
+	}
+
+	// growing a value makes room too
+	loader.PrimeForce("c", "ccccccc")
+	if want := []string{"c"}; !reflect.DeepEqual(keys(), want) {
+		t.Errorf("Keys() got = %v, want %v", keys(), want)
+	}
+	if want := []string{"b", "a"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+
+	// growing a value too big to ever fit evicts the older one
+	loader.PrimeForce("c", "ccccccccccc")
+	if got := keys(); len(got) != 0 {
+		t.Errorf("Keys() got = %v, want none", got)
+	}
+	if want := []string{"b", "a", "c"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+}
+
+func TestLoader_MaxCacheBytesEvictNone(t *testing.T) {
+	fetch := func(keys []string) ([]string, []error) {
+		return make([]string, len(keys)), nil
+	}
+	var evicted []string
+	loader := dataloaden.NewLoader(dataloaden.LoaderConfig[string, string]{
+		Fetch:          fetch,
+		Wait:           1 * time.Millisecond,
+		MaxCacheBytes:  10,
+		SizeOf:         func(value string) int64 { return int64(len(value)) },
+		EvictionPolicy: dataloaden.EvictNone,
+		OnEvict:        func(key string, _ string) { evicted = append(evicted, key) },
+	})
+	keys := func() []string {
+		got := loader.Keys()
+		sort.Strings(got)
+		return got
+	}
+
+	loader.Prime("a", "aaaa")
+	loader.Prime("b", "bbbb")
+	// an update that still fits replaces the value
+	loader.PrimeForce("a", "aaaaaa")
+	if got, _ := loader.Load("a"); got != "aaaaaa" {
+		t.Errorf("Load() got = %v, want %v", got, "aaaaaa")
+	}
+	// one that doesn't fit removes the older value instead of evicting b
+	loader.PrimeForce("a", "aaaaaaa")
+	if want := []string{"b"}; !reflect.DeepEqual(keys(), want) {
+		t.Errorf("Keys() got = %v, want %v", keys(), want)
+	}
+	if want := []string{"a"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted %v, want %v", evicted, want)
+	}
+}
+
+func TestLoader_OnEvict(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	newLoader := func(config dataloaden.LoaderConfig[int, int]) (*dataloaden.Loader[int, int], *[]int) {
+		var evicted []int
+		config.Fetch = fetch
+		config.Wait = 1 * time.Millisecond
+		config.OnEvict = func(key, value int) {
+			evicted = append(evicted, key)
+		}
+		return dataloaden.NewLoader(config), &evicted
+	}
+
+	t.Run("clear", func(t *testing.T) {
+		loader, evicted := newLoader(dataloaden.LoaderConfig[int, int]{})
+		loader.Prime(1, 10)
+		loader.Clear(1)
+		loader.Clear(2)
+		if want := []int{1}; !reflect.DeepEqual(*evicted, want) {
+			t.Errorf("evicted %v, want %v", *evicted, want)
+		}
+	})
+
+	t.Run("ttl", func(t *testing.T) {
+		loader, evicted := newLoader(dataloaden.LoaderConfig[int, int]{TTL: 1 * time.Millisecond})
+		loader.Prime(1, 10)
+		time.Sleep(5 * time.Millisecond)
+		loader.Load(1)
+		if want := []int{1}; !reflect.DeepEqual(*evicted, want) {
+			t.Errorf("evicted %v, want %v", *evicted, want)
+		}
+	})
+
+	t.Run("lru", func(t *testing.T) {
+		loader, evicted := newLoader(dataloaden.LoaderConfig[int, int]{MaxCacheSize: 1})
+		loader.Prime(1, 10)
+		loader.Prime(2, 20)
+		if want := []int{1}; !reflect.DeepEqual(*evicted, want) {
+			t.Errorf("evicted %v, want %v", *evicted, want)
+		}
+	})
+
+	t.Run("clear-all", func(t *testing.T) {
+		loader, evicted := newLoader(dataloaden.LoaderConfig[int, int]{})
+		loader.Prime(1, 10)
+		loader.Prime(2, 20)
+		loader.ClearAll()
+		sort.Ints(*evicted)
+		if want := []int{1, 2}; !reflect.DeepEqual(*evicted, want) {
+			t.Errorf("evicted %v, want %v", *evicted, want)
+		}
+	})
+
+	t.Run("reentrant", func(t *testing.T) {
+		var loader *dataloaden.Loader[int, int]
+		loader = dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+			Fetch:   fetch,
+			Wait:    1 * time.Millisecond,
+			OnEvict: func(key, value int) { loader.Prime(key+1, value) },
+		})
+		loader.Prime(1, 10)
+		loader.Clear(1)
+		if loader.Prime(2, 0) {
+			t.Errorf("OnEvict did not prime the loader")
+		}
+	})
+}
+
+func BenchmarkLoader_CacheCapacity(b *testing.B) {
+	const n = 10000
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	for _, capacity := range []int{0, n} {
+		b.Run(fmt.Sprintf("capacity=%d", capacity), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+					Fetch:         fetch,
+					CacheCapacity: capacity,
+				})
+				for k := 0; k < n; k++ {
+					loader.Prime(k, k)
+				}
+			}
+		})
+	}
+}
+
+func TestLoader_Copy(t *testing.T) {
+	type user struct {
+		Name string
+	}
+	fetch := func(keys []int) ([]*user, []error) {
+		ret := make([]*user, len(keys))
+		for i, key := range keys {
+			ret[i] = &user{Name: fmt.Sprint(key)}
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, *user]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+		Copy: func(u *user) *user {
+			c := *u
+			return &c
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+
+	primed := &user{Name: "primed"}
+	loader.Prime(2, primed)
+	primed.Name = "changed"
+
+	for _, key := range []int{1, 2} {
+		got, err := loader.Load(key)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		got.Name = "changed"
+	}
+	for _, u := range loader.Snapshot() {
+		u.Name = "changed"
+	}
+
+	tests := []struct {
+		key  int
+		want string
+	}{
+		{key: 1, want: "1"},
+		{key: 2, want: "primed"},
+	}
+	for _, tt := range tests {
+		got, err := loader.Load(tt.key)
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if got.Name != tt.want {
+			t.Errorf("Load() got = %v, want %v", got.Name, tt.want)
+		}
+	}
+}
+
+func TestLoader_DisableCache(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:        fetch,
+		Wait:         1 * time.Millisecond,
+		DisableCache: true,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	if got := loader.Prime(1, 1000); got {
+		t.Errorf("Prime() got = %v, want %v", got, false)
+	}
+	for i := 0; i < 3; i++ {
+		// both loads share a batch, but nothing is cached for the next one
+		thunkA, thunkB := loader.LoadThunk(1), loader.LoadThunk(1)
+		a, _ := thunkA()
+		b, _ := thunkB()
+		if a != 10 || b != 10 {
+			t.Errorf("Load() got = %v and %v, want %v", a, b, 10)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("fetch called %v times, want %v", got, 3)
+	}
+	if got := loader.Has(1); got {
+		t.Errorf("Has() got = %v, want %v", got, false)
+	}
+}
+
+func TestLoader_ByteCache(t *testing.T) {
+	type user struct {
+		ID   int
+		Name string
+	}
+	var calls int32
+	fetch := func(keys []int) ([]user, []error) {
+		atomic.AddInt32(&calls, 1)
+		ret := make([]user, len(keys))
+		for i, key := range keys {
+			ret[i] = user{ID: key, Name: fmt.Sprint("user ", key)}
+		}
+		return ret, nil
+	}
+	store := &syncMapCache[int, []byte]{}
+	config := dataloaden.LoaderConfig[int, user]{
+		Fetch:     fetch,
+		Wait:      1 * time.Millisecond,
+		ByteCache: store,
+		Marshal:   func(u user) ([]byte, error) { return json.Marshal(u) },
+		Unmarshal: func(data []byte) (user, error) {
+			var u user
+			err := json.Unmarshal(data, &u)
+			return u, err
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+
+	store.Set(1, []byte(`{"ID":1,"Name":"stored"}`))
+	store.Set(2, []byte(`not json`))
+	loader.Load(3)
+
+	if got, ok := store.Get(3); !ok || string(got) != `{"ID":3,"Name":"user 3"}` {
+		t.Errorf("store.Get() got = %s, %v, want %s, %v", got, ok, `{"ID":3,"Name":"user 3"}`, true)
+	}
+	atomic.StoreInt32(&calls, 0)
+
+	tests := []struct {
+		name      string
+		key       int
+		want      user
+		wantCalls int32
+	}{
+		{name: "decoded", key: 1, want: user{ID: 1, Name: "stored"}, wantCalls: 0},
+		{name: "undecodable is fetched again", key: 2, want: user{ID: 2, Name: "user 2"}, wantCalls: 1},
+		{name: "cached by the loader", key: 3, want: user{ID: 3, Name: "user 3"}, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loader.Load(tt.key)
+			if err != nil {
+				t.Errorf("Load() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Load() got = %v, want %v", got, tt.want)
+			}
+			if got := atomic.LoadInt32(&calls); got != tt.wantCalls {
+				t.Errorf("fetch called %v times, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestLoader_NilValues(t *testing.T) {
+	// odd keys legitimately resolve to nil
+	value := func(key int) *int {
+		if key%2 == 1 {
+			return nil
+		}
+		v := key * 10
+		return &v
+	}
+	tests := []struct {
+		name        string
+		config      func(fetched *int32) dataloaden.LoaderConfig[int, *int]
+		wantFetched int32
+	}{
+		{
+			name: "fetch",
+			config: func(fetched *int32) dataloaden.LoaderConfig[int, *int] {
+				return dataloaden.LoaderConfig[int, *int]{
+					Fetch: func(keys []int) ([]*int, []error) {
+						atomic.AddInt32(fetched, 1)
+						ret := make([]*int, len(keys))
+						for i, key := range keys {
+							ret[i] = value(key)
+						}
+						return ret, nil
+					},
+				}
+			},
+			wantFetched: 1,
+		},
+		{
+			name: "fetch-map",
+			config: func(fetched *int32) dataloaden.LoaderConfig[int, *int] {
+				return dataloaden.LoaderConfig[int, *int]{
+					FetchMap: func(keys []int) (map[int]*int, map[int]error) {
+						atomic.AddInt32(fetched, 1)
+						ret := map[int]*int{}
+						for _, key := range keys {
+							ret[key] = value(key)
+						}
+						return ret, nil
+					},
+				}
+			},
+			wantFetched: 1,
+		},
+		{
+			name: "not-found",
+			config: func(fetched *int32) dataloaden.LoaderConfig[int, *int] {
+				return dataloaden.LoaderConfig[int, *int]{
+					FetchExists: func(_ context.Context, keys []int) ([]*int, []bool, []error) {
+						atomic.AddInt32(fetched, 1)
+						ret := make([]*int, len(keys))
+						found := make([]bool, len(keys))
+						for i, key := range keys {
+							ret[i], found[i] = value(key), value(key) != nil
+						}
+						return ret, found, nil
+					},
+				}
+			},
+			wantFetched: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetched int32
+			config := tt.config(&fetched)
+			config.Wait = 1 * time.Millisecond
+			loader := dataloaden.NewLoader(config)
+
+			for i := 0; i < 2; i++ {
+				got, errs := loader.LoadAll([]int{1, 2})
+				if got[0] != nil || got[1] == nil || *got[1] != 20 {
+					t.Errorf("LoadAll() got = %v, want [nil 20]", got)
+				}
+				if errs[0] != nil || errs[1] != nil {
+					t.Errorf("LoadAll() errs = %v, want none", errs)
+				}
+			}
+			if got := atomic.LoadInt32(&fetched); got != tt.wantFetched {
+				t.Errorf("fetch called %v times, want %v", got, tt.wantFetched)
+			}
+		})
+	}
+}
+
+func TestLoader_IndexFunc(t *testing.T) {
+	type user struct {
+		ID    string
+		Email string
+	}
+	users := []user{{ID: "1", Email: "a@example.com"}, {ID: "2", Email: "b@example.com"}}
+	fetch := func(keys []string) ([]user, []error) {
+		ret := make([]user, len(keys))
+		for i, key := range keys {
+			for _, u := range users {
+				if key == u.ID || key == u.Email {
+					ret[i] = u
+				}
+			}
+		}
+		return ret, nil
+	}
+	tests := []struct {
+		name  string
+		clear func(loader *dataloaden.Loader[string, user])
+	}{
+		{name: "by-index", clear: func(loader *dataloaden.Loader[string, user]) { loader.ClearByIndex("a@example.com") }},
+		{name: "by-index-normalized", clear: func(loader *dataloaden.Loader[string, user]) { loader.ClearByIndex("A@Example.com") }},
+		{name: "by-id", clear: func(loader *dataloaden.Loader[string, user]) { loader.Clear("1") }},
+		{name: "by-email", clear: func(loader *dataloaden.Loader[string, user]) { loader.Clear("a@example.com") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := dataloaden.LoaderConfig[string, user]{
+				Fetch:     fetch,
+				Wait:      1 * time.Millisecond,
+				IndexFunc: func(u user) []string { return []string{u.ID, u.Email} },
+				Normalize: strings.ToLower,
+			}
+			loader := dataloaden.NewLoader(config)
+			loader.LoadAll([]string{"1", "a@example.com", "2"})
+
+			tt.clear(loader)
+
+			keys := loader.Keys()
+			sort.Strings(keys)
+			if want := []string{"2"}; !reflect.DeepEqual(keys, want) {
+				t.Errorf("Keys() got = %v, want %v", keys, want)
+			}
+		})
+	}
+}
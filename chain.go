@@ -0,0 +1,63 @@
+package dataloaden
+
+import (
+	"context"
+	"time"
+)
+
+// ChainConfig configures how the Loader returned by Chain batches keys
+type ChainConfig struct {
+	// Wait is how long to wait before sending a batch, see LoaderConfig.Wait
+	Wait time.Duration
+
+	// MaxBatch limits the number of keys in a batch, 0 = no limit, see LoaderConfig.MaxBatch
+	MaxBatch int
+}
+
+// Chain returns a Loader loading every key from primary first, e.g. a fast cache tier, and falling back to
+// fallback, e.g. the authoritative store, for the keys primary misses or fails. A key is missed when primary
+// resolves it with no value and no error, as FetchExists, FetchMap or NegativeTTL do for keys that are not
+// found. A key primary fails with an error is loaded from fallback too, so its error is only returned if
+// fallback also fails, in which case fallback's error is. Values found by fallback are primed into primary,
+// overwriting what it has.
+//
+// The returned Loader batches keys as config says and passes them on to primary and fallback, whose batching
+// and caching apply as usual. It caches nothing itself, so clearing it has no effect: clear primary and
+// fallback instead. It panics if config is invalid, like NewLoader.
+func Chain[K comparable, V any](primary, fallback *Loader[K, V], config ChainConfig) *Loader[K, V] {
+	return NewLoader(LoaderConfig[K, V]{
+		FetchExists: func(ctx context.Context, keys []K) ([]V, []bool, []error) {
+			data := make([]V, len(keys))
+			found := make([]bool, len(keys))
+			errs := make([]error, len(keys))
+			thunks := make([]func() (V, bool, error), len(keys))
+			for i, key := range keys {
+				thunks[i] = primary.loadThunk(ctx, key, nil)
+			}
+			var missed []int
+			for i, thunk := range thunks {
+				data[i], found[i], errs[i] = thunk()
+				if !found[i] || errs[i] != nil {
+					missed = append(missed, i)
+				}
+			}
+			if len(missed) == 0 {
+				return data, found, errs
+			}
+
+			for _, i := range missed {
+				thunks[i] = fallback.loadThunk(ctx, keys[i], nil)
+			}
+			for _, i := range missed {
+				data[i], found[i], errs[i] = thunks[i]()
+				if found[i] {
+					primary.PrimeForce(keys[i], data[i])
+				}
+			}
+			return data, found, errs
+		},
+		Wait:         config.Wait,
+		MaxBatch:     config.MaxBatch,
+		DisableCache: true,
+	})
+}
@@ -0,0 +1,81 @@
+package dataloaden_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestChain(t *testing.T) {
+	errDown := errors.New("down")
+	errMissing := errors.New("missing")
+	// the primary has even keys, fails key 3 and misses the others
+	primary := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+		FetchExists: func(_ context.Context, keys []int) ([]int, []bool, []error) {
+			data := make([]int, len(keys))
+			found := make([]bool, len(keys))
+			errs := make([]error, len(keys))
+			for i, key := range keys {
+				switch {
+				case key%2 == 0:
+					data[i], found[i] = key*10, true
+				case key == 3:
+					errs[i] = errDown
+				}
+			}
+			return data, found, errs
+		},
+		Wait: 1 * time.Millisecond,
+	})
+	var mu sync.Mutex
+	var fetched [][]int
+	// the fallback has every key but 5
+	fallback := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+		Fetch: func(keys []int) ([]int, []error) {
+			mu.Lock()
+			fetched = append(fetched, keys)
+			mu.Unlock()
+			data := make([]int, len(keys))
+			errs := make([]error, len(keys))
+			for i, key := range keys {
+				if key == 5 {
+					errs[i] = errMissing
+					continue
+				}
+				data[i] = key * 100
+			}
+			return data, errs
+		},
+		Wait: 1 * time.Millisecond,
+	})
+	loader := dataloaden.Chain(primary, fallback, dataloaden.ChainConfig{Wait: 1 * time.Millisecond})
+
+	got, errs := loader.LoadAll([]int{1, 2, 3, 4, 5})
+	if want := []int{100, 20, 300, 40, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if want := []error{nil, nil, nil, nil, errMissing}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("LoadAll() errs = %v, want %v", errs, want)
+	}
+
+	// values found by the fallback are primed into the primary
+	for key, want := range map[int]int{1: 100, 3: 300} {
+		if got, _ := primary.Load(key); got != want {
+			t.Errorf("primary Load(%v) got = %v, want %v", key, got, want)
+		}
+	}
+	if got, _ := loader.Load(1); got != 100 {
+		t.Errorf("Load() got = %v, want %v", got, 100)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := [][]int{{1, 3, 5}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fallback fetched %v, want %v", fetched, want)
+	}
+}
@@ -0,0 +1,30 @@
+package dataloaden
+
+import "time"
+
+// Clock tells the time and schedules functions for a Loader.
+// It can be replaced to control time in tests.
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+
+	// AfterFunc calls f in its own goroutine after d has passed
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a function call scheduled by a Clock
+type Timer interface {
+	// Stop prevents the call, and reports whether it did
+	Stop() bool
+}
+
+// realClock is the default Clock, backed by the time package
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
@@ -0,0 +1,321 @@
+package dataloaden_test
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+// fakeClock is a dataloaden.Clock whose time only moves by calling Advance.
+// due functions are called synchronously by Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *fakeClock
+	at    time.Time
+	f     func()
+	done  bool
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) dataloaden.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	for _, t := range c.timers {
+		if !t.done && !t.at.After(c.now) {
+			t.done = true
+			due = append(due, t)
+		}
+	}
+	c.mu.Unlock()
+	for _, t := range due {
+		t.f()
+	}
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	stopped := !t.done
+	t.done = true
+	return stopped
+}
+
+func TestLoader_Clock(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		return make([]int, len(keys)), nil
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  10 * time.Millisecond,
+		Clock: clock,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	thunk := loader.LoadThunk(1)
+	clock.Advance(5 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("fetch called %v times before Wait, want %v", got, 0)
+	}
+	clock.Advance(5 * time.Millisecond)
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fetch called %v times after Wait, want %v", got, 1)
+	}
+	if _, err := thunk(); err != nil {
+		t.Errorf("thunk() error = %v", err)
+	}
+}
+
+func TestLoader_WaitJitter(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		return make([]int, len(keys)), nil
+	}
+	for i := 0; i < 10; i++ {
+		atomic.StoreInt32(&calls, 0)
+		clock := newFakeClock()
+		config := dataloaden.LoaderConfig[int, int]{
+			Fetch:      fetch,
+			Wait:       10 * time.Millisecond,
+			WaitJitter: 10 * time.Millisecond,
+			Clock:      clock,
+		}
+		loader := dataloaden.NewLoader(config)
+
+		loader.LoadThunk(1)
+		clock.Advance(10*time.Millisecond - time.Nanosecond)
+		if got := atomic.LoadInt32(&calls); got != 0 {
+			t.Errorf("fetch called %v times before Wait, want %v", got, 0)
+		}
+		clock.Advance(10 * time.Millisecond)
+		if got := atomic.LoadInt32(&calls); got != 1 {
+			t.Errorf("fetch called %v times after Wait+WaitJitter, want %v", got, 1)
+		}
+	}
+}
+
+func TestLoader_Rand(t *testing.T) {
+	const seed = 42
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		return make([]int, len(keys)), nil
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:      fetch,
+		Wait:       10 * time.Millisecond,
+		WaitJitter: 10 * time.Millisecond,
+		Clock:      clock,
+		Rand:       rand.New(rand.NewSource(seed)),
+	}
+	loader := dataloaden.NewLoader(config)
+
+	// the same seed gives the same jitter
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 5; i++ {
+		atomic.StoreInt32(&calls, 0)
+		wait := 10*time.Millisecond + time.Duration(r.Int63n(int64(10*time.Millisecond)))
+		loader.LoadThunk(i)
+		clock.Advance(wait - time.Nanosecond)
+		if got := atomic.LoadInt32(&calls); got != 0 {
+			t.Errorf("fetch called %v times before %v, want %v", got, wait, 0)
+		}
+		clock.Advance(time.Nanosecond)
+		if got := atomic.LoadInt32(&calls); got != 1 {
+			t.Errorf("fetch called %v times after %v, want %v", got, wait, 1)
+		}
+	}
+}
+
+func TestLoader_MaxWait(t *testing.T) {
+	var fetched [][]int
+	fetch := func(keys []int) ([]int, []error) {
+		fetched = append(fetched, keys)
+		return make([]int, len(keys)), nil
+	}
+	tests := []struct {
+		name   string
+		config dataloaden.LoaderConfig[int, int]
+		want   [][]int
+	}{
+		{
+			name:   "wait-is-not-extended",
+			config: dataloaden.LoaderConfig[int, int]{Wait: 10 * time.Millisecond},
+			want:   [][]int{{0, 1, 2, 3, 4}, {5, 6, 7, 8, 9}},
+		},
+		{
+			name:   "max-wait-caps-jitter",
+			config: dataloaden.LoaderConfig[int, int]{Wait: 10 * time.Millisecond, WaitJitter: time.Hour, MaxWait: 6 * time.Millisecond},
+			want:   [][]int{{0, 1, 2}, {3, 4, 5}, {6, 7, 8}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetched = nil
+			clock := newFakeClock()
+			tt.config.Fetch = fetch
+			tt.config.Clock = clock
+			loader := dataloaden.NewLoader(tt.config)
+
+			// a key every 2ms
+			for i := 0; i < 10; i++ {
+				loader.LoadThunk(i)
+				clock.Advance(2 * time.Millisecond)
+			}
+			if !reflect.DeepEqual(fetched, tt.want) {
+				t.Errorf("fetched %v, want %v", fetched, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoader_DebounceMode(t *testing.T) {
+	var fetched [][]int
+	fetch := func(keys []int) ([]int, []error) {
+		fetched = append(fetched, keys)
+		return make([]int, len(keys)), nil
+	}
+	tests := []struct {
+		name   string
+		config dataloaden.LoaderConfig[int, int]
+		want   [][]int
+	}{
+		{
+			name:   "wait-restarts-on-every-key",
+			config: dataloaden.LoaderConfig[int, int]{Wait: 3 * time.Millisecond, DebounceMode: true},
+			want:   [][]int{{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		},
+		{
+			name:   "max-wait-caps-the-wait",
+			config: dataloaden.LoaderConfig[int, int]{Wait: 3 * time.Millisecond, MaxWait: 6 * time.Millisecond, DebounceMode: true},
+			want:   [][]int{{0, 1, 2}, {3, 4, 5}, {6, 7, 8}, {9}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetched = nil
+			clock := newFakeClock()
+			tt.config.Fetch = fetch
+			tt.config.Clock = clock
+			loader := dataloaden.NewLoader(tt.config)
+
+			// a key every 2ms, each before the wait of the previous one is over
+			thunks := make([]func() (int, error), 10)
+			for i := range thunks {
+				thunks[i] = loader.LoadThunk(i)
+				clock.Advance(2 * time.Millisecond)
+			}
+			// the last batch is only sent once no key was loaded for Wait
+			clock.Advance(time.Millisecond - time.Nanosecond)
+			if got, want := len(fetched), len(tt.want)-1; got != want {
+				t.Errorf("fetched %v batches before quiescence, want %v", got, want)
+			}
+			clock.Advance(time.Nanosecond)
+			for _, thunk := range thunks {
+				thunk()
+			}
+			if !reflect.DeepEqual(fetched, tt.want) {
+				t.Errorf("fetched %v, want %v", fetched, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoader_Reconfigure(t *testing.T) {
+	var fetched [][]int
+	fetch := func(keys []int) ([]int, []error) {
+		fetched = append(fetched, keys)
+		return make([]int, len(keys)), nil
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  10 * time.Millisecond,
+		Clock: clock,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.LoadAllThunk([]int{1, 2})
+	loader.Reconfigure(5*time.Millisecond, 2)
+	loader.LoadThunk(3)
+	clock.Advance(10 * time.Millisecond)
+	if want := [][]int{{1, 2, 3}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+
+	fetched = nil
+	thunk := loader.LoadAllThunk([]int{4, 5})
+	thunk()
+	if want := [][]int{{4, 5}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+	loader.LoadThunk(6)
+	clock.Advance(5 * time.Millisecond)
+	if want := [][]int{{4, 5}, {6}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_ConfigGetters(t *testing.T) {
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:    func(keys []int) ([]int, []error) { return make([]int, len(keys)), nil },
+		Wait:     10 * time.Millisecond,
+		MaxWait:  20 * time.Millisecond,
+		MaxBatch: 100,
+		TTL:      time.Minute,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	if got := loader.Wait(); got != config.Wait {
+		t.Errorf("Wait() got = %v, want %v", got, config.Wait)
+	}
+	if got := loader.MaxWait(); got != config.MaxWait {
+		t.Errorf("MaxWait() got = %v, want %v", got, config.MaxWait)
+	}
+	if got := loader.MaxBatch(); got != config.MaxBatch {
+		t.Errorf("MaxBatch() got = %v, want %v", got, config.MaxBatch)
+	}
+	if got := loader.TTL(); got != config.TTL {
+		t.Errorf("TTL() got = %v, want %v", got, config.TTL)
+	}
+
+	loader.Reconfigure(5*time.Millisecond, 2)
+	if got := loader.Wait(); got != 5*time.Millisecond {
+		t.Errorf("Wait() got = %v, want %v", got, 5*time.Millisecond)
+	}
+	if got := loader.MaxBatch(); got != 2 {
+		t.Errorf("MaxBatch() got = %v, want %v", got, 2)
+	}
+}
@@ -0,0 +1,45 @@
+package dataloaden
+
+import "context"
+
+// fetchingKey is the context key of the batches being fetched by the fetch a context was passed to,
+// and by the fetches that loaded their keys, see LoaderConfig.DetectDeadlocks
+type fetchingKey struct{}
+
+// fetchingBatches returns the batches being fetched up the chain of fetches ctx was passed down
+func fetchingBatches(ctx context.Context) map[any]struct{} {
+	batches, _ := ctx.Value(fetchingKey{}).(map[any]struct{})
+	return batches
+}
+
+// unsafeFetchContext returns the context to pass to the fetch of the batch, recording the batch
+// and the batches up its chain of fetches. l.mu must be held.
+func (b *loaderBatch[K, V]) unsafeFetchContext() context.Context {
+	batches := make(map[any]struct{}, len(b.callers)+1)
+	for batch := range b.callers {
+		batches[batch] = struct{}{}
+	}
+	batches[b] = struct{}{}
+	return context.WithValue(b.ctx, fetchingKey{}, batches)
+}
+
+// unsafeWatchFetching records the batches up the chain of fetches of a caller of the batch.
+// l.mu must be held.
+func (b *loaderBatch[K, V]) unsafeWatchFetching(l *Loader[K, V], ctx context.Context) {
+	if !l.detectDeadlocks {
+		return
+	}
+	for batch := range fetchingBatches(ctx) {
+		if b.callers == nil {
+			b.callers = map[any]struct{}{}
+		}
+		b.callers[batch] = struct{}{}
+	}
+}
+
+// deadlocked reports whether waiting on batch from a load with ctx would never end,
+// because the load comes from inside the fetch of batch itself
+func deadlocked[K comparable, V any](ctx context.Context, batch *loaderBatch[K, V]) bool {
+	_, ok := fetchingBatches(ctx)[batch]
+	return ok
+}
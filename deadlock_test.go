@@ -0,0 +1,44 @@
+package dataloaden_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestLoader_DetectDeadlocks(t *testing.T) {
+	var a, b *dataloaden.Loader[int, int]
+	a = dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+		FetchContext: func(ctx context.Context, keys []int) ([]int, []error) {
+			// a needs b, which needs a again
+			vs, errs := b.LoadAllContext(ctx, keys)
+			return vs, errs
+		},
+		Wait:            1 * time.Millisecond,
+		DetectDeadlocks: true,
+	})
+	b = dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+		FetchContext: func(ctx context.Context, keys []int) ([]int, []error) {
+			return a.LoadAllContext(ctx, keys)
+		},
+		Wait:            1 * time.Millisecond,
+		DetectDeadlocks: true,
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := a.Load(1)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, dataloaden.ErrDeadlock) {
+			t.Errorf("Load() error = %v, want %v", err, dataloaden.ErrDeadlock)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Load() deadlocked")
+	}
+}
@@ -0,0 +1,42 @@
+package dataloaden
+
+import "sync"
+
+// Dispatcher sends the pending batches of many loaders at once, e.g. once a GraphQL resolver has
+// loaded everything a layer of the query needs. It is safe for concurrent use.
+type Dispatcher struct {
+	mu      sync.Mutex
+	loaders []interface{ Flush() }
+}
+
+// NewDispatcher creates a new Dispatcher with no loaders
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{}
+}
+
+// Register adds loader, usually a *Loader, to the loaders DispatchAll sends the batches of
+func (d *Dispatcher) Register(loader interface{ Flush() }) {
+	d.mu.Lock()
+	d.loaders = append(d.loaders, loader)
+	d.mu.Unlock()
+}
+
+// DispatchAll sends the batch still collecting keys of every registered loader right away, like
+// Loader.Flush does, and returns once they are all fetched and their thunks resolve.
+// The batches are fetched concurrently.
+func (d *Dispatcher) DispatchAll() {
+	d.mu.Lock()
+	loaders := make([]interface{ Flush() }, len(d.loaders))
+	copy(loaders, d.loaders)
+	d.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, loader := range loaders {
+		wg.Add(1)
+		go func(loader interface{ Flush() }) {
+			defer wg.Done()
+			loader.Flush()
+		}(loader)
+	}
+	wg.Wait()
+}
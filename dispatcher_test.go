@@ -0,0 +1,53 @@
+package dataloaden_test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestDispatcher_DispatchAll(t *testing.T) {
+	users := dataloaden.NewLoader(dataloaden.LoaderConfig[int, string]{
+		Fetch: func(keys []int) ([]string, []error) {
+			ret := make([]string, len(keys))
+			for i, key := range keys {
+				ret[i] = "user " + strconv.Itoa(key)
+			}
+			return ret, nil
+		},
+		Wait: time.Hour,
+	})
+	posts := dataloaden.NewLoader(dataloaden.LoaderConfig[string, int]{
+		Fetch: func(keys []string) ([]int, []error) {
+			ret := make([]int, len(keys))
+			for i, key := range keys {
+				ret[i] = len(key)
+			}
+			return ret, nil
+		},
+		Wait: time.Hour,
+	})
+	dispatcher := dataloaden.NewDispatcher()
+	dispatcher.Register(users)
+	dispatcher.Register(posts)
+
+	user := users.LoadThunk(1)
+	post := posts.LoadThunk("hello")
+	if got := users.PendingCount() + posts.PendingCount(); got != 2 {
+		t.Errorf("PendingCount() got = %v, want %v", got, 2)
+	}
+
+	dispatcher.DispatchAll()
+
+	if got := users.PendingCount() + posts.PendingCount(); got != 0 {
+		t.Errorf("PendingCount() got = %v, want %v", got, 0)
+	}
+	if got, _ := user(); got != "user 1" {
+		t.Errorf("Load() got = %v, want %v", got, "user 1")
+	}
+	if got, _ := post(); got != 5 {
+		t.Errorf("Load() got = %v, want %v", got, 5)
+	}
+}
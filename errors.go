@@ -0,0 +1,127 @@
+package dataloaden
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrResultLength is returned for every key of a batch whose fetch returned
+// a number of values or errors that doesn't match the keys.
+var ErrResultLength = errors.New("dataloaden: fetch returned a wrong number of results")
+
+// ErrNoResult is returned for the keys fetch returned neither a value nor an error for,
+// when LoaderConfig.RequireResults is set.
+var ErrNoResult = errors.New("dataloaden: fetch returned no result")
+
+// ErrClosed is returned when loading from a Loader that has been stopped.
+var ErrClosed = errors.New("dataloaden: loader is closed")
+
+// ErrFetchTimeout is returned for every key of a batch whose fetch took longer than LoaderConfig.FetchTimeout.
+var ErrFetchTimeout = errors.New("dataloaden: fetch timed out")
+
+// ErrLoadTimeout is returned by a thunk that waited for its batch longer than LoaderConfig.LoadTimeout.
+var ErrLoadTimeout = errors.New("dataloaden: load timed out")
+
+// ErrDeadlock is returned when a load from inside fetch would wait for the batch being fetched,
+// see LoaderConfig.DetectDeadlocks.
+var ErrDeadlock = errors.New("dataloaden: load would wait for the batch being fetched")
+
+// ErrReset is returned by the thunks of a batch cancelled by Loader.Reset.
+var ErrReset = errors.New("dataloaden: loader was reset")
+
+// ErrInvalidConfig is returned by NewLoaderChecked for a LoaderConfig that can't make a working Loader.
+var ErrInvalidConfig = errors.New("dataloaden: invalid loader config")
+
+// SingleErrorMode is how a single error returned by fetch for a batch of many keys is applied,
+// see LoaderConfig.SingleError.
+type SingleErrorMode int
+
+const (
+	// SingleErrorBroadcast applies the error to every key of the batch. It is the default.
+	SingleErrorBroadcast SingleErrorMode = iota
+
+	// SingleErrorFirstKey applies the error to the first key of the batch only,
+	// as if fetch had returned one error per key with no error for the others.
+	SingleErrorFirstKey
+)
+
+// PanicError is returned for every key of a batch whose fetch panicked.
+type PanicError struct {
+	// Value is the value recovered from the panic
+	Value any
+
+	// Stack is the stack trace of the goroutine that panicked
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("dataloaden: fetch panicked: %v", e.Value)
+}
+
+// Unwrap returns the recovered value if it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// KeyError is the error of a single key, returned when loading many keys stops at the first failure.
+type KeyError[K comparable] struct {
+	// Key is the key that failed
+	Key K
+
+	// Err is the error it failed with
+	Err error
+}
+
+func (e *KeyError[K]) Error() string {
+	return fmt.Sprintf("dataloaden: key %v failed: %v", e.Key, e.Err)
+}
+
+// Unwrap returns the error of the key
+func (e *KeyError[K]) Unwrap() error {
+	return e.Err
+}
+
+// BatchError aggregates the errors of the keys that failed when loading many keys.
+type BatchError[K comparable] struct {
+	// failed keys and their errors, in the order they were loaded
+	keys []K
+	errs []error
+
+	// number of keys loaded
+	total int
+}
+
+// NewBatchError aggregates the non-nil errors in errs, which are matched up with keys.
+// It returns nil if there are none.
+func NewBatchError[K comparable](keys []K, errs []error) *BatchError[K] {
+	e := &BatchError[K]{total: len(keys)}
+	for i, err := range errs {
+		if err != nil {
+			e.keys = append(e.keys, keys[i])
+			e.errs = append(e.errs, err)
+		}
+	}
+	if len(e.errs) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *BatchError[K]) Error() string {
+	return fmt.Sprintf("dataloaden: %d of %d keys failed, first error: %v", len(e.errs), e.total, e.errs[0])
+}
+
+// Failures returns the error of every key that failed
+func (e *BatchError[K]) Failures() map[K]error {
+	failures := make(map[K]error, len(e.keys))
+	for i, key := range e.keys {
+		failures[key] = e.errs[i]
+	}
+	return failures
+}
+
+// Unwrap returns the errors of the keys that failed
+func (e *BatchError[K]) Unwrap() []error {
+	return e.errs
+}
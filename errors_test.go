@@ -0,0 +1,315 @@
+package dataloaden_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestLoader_LoadAllAggregate(t *testing.T) {
+	errOdd := errors.New("odd key")
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		retErr := make([]error, len(keys))
+		for i := range keys {
+			if keys[i]%2 == 0 {
+				ret[i] = keys[i] * 10
+			} else {
+				retErr[i] = errOdd
+			}
+		}
+		return ret, retErr
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	got, err := loader.LoadAllAggregate([]int{0, 2})
+	if err != nil {
+		t.Errorf("LoadAllAggregate() error = %v, want nil", err)
+	}
+	if want := []int{0, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAllAggregate() got = %v, want %v", got, want)
+	}
+
+	got, err = loader.LoadAllAggregate([]int{1, 2, 3})
+	var batchErr *dataloaden.BatchError[int]
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("LoadAllAggregate() error = %v, want *BatchError", err)
+	}
+	if want := map[int]error{1: errOdd, 3: errOdd}; !reflect.DeepEqual(batchErr.Failures(), want) {
+		t.Errorf("Failures() got = %v, want %v", batchErr.Failures(), want)
+	}
+	if want := []int{0, 20, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAllAggregate() got = %v, want %v", got, want)
+	}
+}
+
+func TestLoader_SingleError(t *testing.T) {
+	errFetch := errors.New("fetch failed")
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, []error{errFetch}
+	}
+	tests := []struct {
+		name     string
+		mode     dataloaden.SingleErrorMode
+		want     []int
+		wantErrs []error
+	}{
+		{
+			name:     "broadcast",
+			mode:     dataloaden.SingleErrorBroadcast,
+			want:     []int{10, 20, 30},
+			wantErrs: []error{errFetch, errFetch, errFetch},
+		},
+		{
+			name:     "first key",
+			mode:     dataloaden.SingleErrorFirstKey,
+			want:     []int{10, 20, 30},
+			wantErrs: []error{errFetch, nil, nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+				Fetch:       fetch,
+				Wait:        1 * time.Millisecond,
+				SingleError: tt.mode,
+			})
+			got, errs := loader.LoadAll([]int{1, 2, 3})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadAll() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(errs, tt.wantErrs) {
+				t.Errorf("LoadAll() errs = %v, want %v", errs, tt.wantErrs)
+			}
+		})
+	}
+}
+
+func TestLoader_LoadAllOrError(t *testing.T) {
+	errOdd := errors.New("odd key")
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		retErr := make([]error, len(keys))
+		for i := range keys {
+			if keys[i]%2 == 0 {
+				ret[i] = keys[i] * 10
+			} else {
+				retErr[i] = errOdd
+			}
+		}
+		return ret, retErr
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	tests := []struct {
+		name    string
+		keys    []int
+		want    []int
+		wantKey int
+		wantErr bool
+	}{
+		{name: "all success", keys: []int{0, 2, 4, 2}, want: []int{0, 20, 40, 20}},
+		{name: "early error", keys: []int{2, 3, 4, 5}, wantKey: 3, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := dataloaden.NewLoader(config)
+			got, err := loader.LoadAllOrError(tt.keys)
+			if !tt.wantErr {
+				if err != nil {
+					t.Errorf("LoadAllOrError() error = %v, want nil", err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("LoadAllOrError() got = %v, want %v", got, tt.want)
+				}
+				return
+			}
+			var keyErr *dataloaden.KeyError[int]
+			if !errors.As(err, &keyErr) {
+				t.Fatalf("LoadAllOrError() error = %v, want *KeyError", err)
+			}
+			if keyErr.Key != tt.wantKey {
+				t.Errorf("KeyError.Key = %v, want %v", keyErr.Key, tt.wantKey)
+			}
+			if !errors.Is(err, errOdd) {
+				t.Errorf("LoadAllOrError() error = %v, want %v", err, errOdd)
+			}
+			if got != nil {
+				t.Errorf("LoadAllOrError() got = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestLoader_LoadAllG(t *testing.T) {
+	errOdd := errors.New("odd key")
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		retErr := make([]error, len(keys))
+		for i := range keys {
+			if keys[i]%2 == 0 {
+				ret[i] = keys[i] * 10
+			} else {
+				retErr[i] = errOdd
+			}
+		}
+		return ret, retErr
+	}
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	tests := []struct {
+		name    string
+		ctx     context.Context
+		wait    time.Duration
+		keys    []int
+		want    []int
+		wantErr error
+	}{
+		{name: "success", ctx: context.Background(), wait: time.Millisecond, keys: []int{2, 4}, want: []int{20, 40}},
+		{name: "failing key", ctx: context.Background(), wait: time.Millisecond, keys: []int{2, 3}, wantErr: errOdd},
+		{name: "cancelled context", ctx: cancelled, wait: time.Hour, keys: []int{2, 4}, wantErr: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+				Fetch: fetch,
+				Wait:  tt.wait,
+			})
+			got, err := loader.LoadAllG(tt.ctx, tt.keys)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("LoadAllG() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadAllG() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoader_ShortErrors(t *testing.T) {
+	someErr := errors.New("some error")
+	tests := []struct {
+		name   string
+		config dataloaden.LoaderConfig[int, int]
+	}{
+		{
+			name: "fetch",
+			config: dataloaden.LoaderConfig[int, int]{
+				Fetch: func(keys []int) ([]int, []error) { return nil, []error{nil, someErr} },
+			},
+		},
+		{
+			name: "after-fetch",
+			config: dataloaden.LoaderConfig[int, int]{
+				Fetch: func(keys []int) ([]int, []error) { return make([]int, len(keys)), nil },
+				AfterFetch: func(keys []int, values []int, errs []error) ([]int, []error) {
+					return values, []error{nil, someErr}
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Wait = 1 * time.Millisecond
+			loader := dataloaden.NewLoader(tt.config)
+			_, errs := loader.LoadAll([]int{1, 2, 3})
+			for i, err := range errs {
+				if !errors.Is(err, dataloaden.ErrResultLength) {
+					t.Errorf("LoadAll() error[%d] = %v, want %v", i, err, dataloaden.ErrResultLength)
+				}
+			}
+		})
+	}
+}
+
+func TestLoader_RequireResults(t *testing.T) {
+	someErr := errors.New("some error")
+	tests := []struct {
+		name    string
+		errs    []error
+		require bool
+		wantErr []string
+	}{
+		{name: "off", errs: nil, require: false, wantErr: []string{"", "", ""}},
+		{name: "nil-results", errs: nil, require: true, wantErr: []string{
+			"dataloaden: fetch returned no result for key 1",
+			"dataloaden: fetch returned no result for key 2",
+			"dataloaden: fetch returned no result for key 3",
+		}},
+		{name: "some-errors", errs: []error{nil, someErr, nil}, require: true, wantErr: []string{
+			"dataloaden: fetch returned no result for key 1",
+			"some error",
+			"dataloaden: fetch returned no result for key 3",
+		}},
+		{name: "single-error", errs: []error{someErr}, require: true, wantErr: []string{"some error", "some error", "some error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := dataloaden.LoaderConfig[int, int]{
+				Fetch:          func(keys []int) ([]int, []error) { return nil, tt.errs },
+				Wait:           1 * time.Millisecond,
+				RequireResults: tt.require,
+			}
+			loader := dataloaden.NewLoader(config)
+			_, errs := loader.LoadAll([]int{1, 2, 3})
+			for i, err := range errs {
+				got := ""
+				if err != nil {
+					got = err.Error()
+				}
+				if got != tt.wantErr[i] {
+					t.Errorf("LoadAll() error[%d] = %q, want %q", i, got, tt.wantErr[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoader_ErrNoResult(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		// only the first key is populated
+		return []int{keys[0] * 10}, nil
+	}
+	tests := []struct {
+		name    string
+		require bool
+		want    []int
+		wantErr []error
+	}{
+		{name: "off", require: false, want: []int{0, 0, 0}, wantErr: []error{dataloaden.ErrResultLength, dataloaden.ErrResultLength, dataloaden.ErrResultLength}},
+		{name: "require-results", require: true, want: []int{10, 0, 0}, wantErr: []error{nil, dataloaden.ErrNoResult, dataloaden.ErrNoResult}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := dataloaden.LoaderConfig[int, int]{
+				Fetch:          fetch,
+				Wait:           1 * time.Millisecond,
+				RequireResults: tt.require,
+			}
+			loader := dataloaden.NewLoader(config)
+			got, errs := loader.LoadAll([]int{1, 2, 3})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadAll() got = %v, want %v", got, tt.want)
+			}
+			for i, err := range errs {
+				if !errors.Is(err, tt.wantErr[i]) || (err == nil) != (tt.wantErr[i] == nil) {
+					t.Errorf("LoadAll() error[%d] = %v, want %v", i, err, tt.wantErr[i])
+				}
+			}
+		})
+	}
+}
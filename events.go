@@ -0,0 +1,71 @@
+package dataloaden
+
+import "time"
+
+// eventBuffer is how many events Loader.Events holds for a slow consumer before dropping them
+const eventBuffer = 256
+
+// BatchEventType is what happened to a batch, see BatchEvent
+type BatchEventType int
+
+const (
+	// BatchOpened is sent when the first key of a batch is loaded
+	BatchOpened BatchEventType = iota
+
+	// BatchDispatched is sent when a batch starts being fetched
+	BatchDispatched
+
+	// BatchCompleted is sent when a batch is fetched, before its thunks resolve
+	BatchCompleted
+)
+
+func (t BatchEventType) String() string {
+	switch t {
+	case BatchOpened:
+		return "opened"
+	case BatchDispatched:
+		return "dispatched"
+	case BatchCompleted:
+		return "completed"
+	}
+	return "unknown"
+}
+
+// BatchEvent is something that happened to a batch, see Loader.Events
+type BatchEvent[K comparable] struct {
+	Type BatchEventType
+
+	// Keys is the keys of the batch so far. they must not be modified.
+	Keys []K
+
+	// Time is when it happened
+	Time time.Time
+
+	// Duration is how long the batch collected keys for BatchDispatched,
+	// how long fetch took for BatchCompleted, and 0 for BatchOpened
+	Duration time.Duration
+}
+
+// Events returns a channel of the events of the batches opened from now on. Every call returns the
+// same channel, which is never closed. The loader never blocks on it: once it holds 256 events that
+// are not received yet, later events are dropped until there is room again. A batch cancelled by
+// CancelPending or Reset is opened but never dispatched nor completed.
+func (l *Loader[K, V]) Events() <-chan BatchEvent[K] {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.events == nil {
+		l.events = make(chan BatchEvent[K], eventBuffer)
+	}
+	return l.events
+}
+
+// send sends an event of the batch, if anyone subscribed to events before the batch was opened
+func (b *loaderBatch[K, V]) send(typ BatchEventType, now time.Time, duration time.Duration) {
+	if b.events == nil {
+		return
+	}
+	select {
+	case b.events <- BatchEvent[K]{Type: typ, Keys: b.keys, Time: now, Duration: duration}:
+	default:
+	}
+}
@@ -0,0 +1,59 @@
+package dataloaden_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestLoader_Events(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		time.Sleep(2 * time.Millisecond)
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  5 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Load(0) // before subscribing, so not sent
+
+	events := loader.Events()
+	if again := loader.Events(); again != events {
+		t.Errorf("Events() returned another channel")
+	}
+	loader.LoadAll([]int{1, 2})
+
+	tests := []struct {
+		typ         dataloaden.BatchEventType
+		keys        []int
+		minDuration time.Duration
+	}{
+		{typ: dataloaden.BatchOpened, keys: []int{1}},
+		{typ: dataloaden.BatchDispatched, keys: []int{1, 2}, minDuration: 5 * time.Millisecond},
+		{typ: dataloaden.BatchCompleted, keys: []int{1, 2}, minDuration: 2 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		select {
+		case event := <-events:
+			if event.Type != tt.typ {
+				t.Errorf("event type = %v, want %v", event.Type, tt.typ)
+			}
+			if !reflect.DeepEqual(event.Keys, tt.keys) {
+				t.Errorf("%v event keys = %v, want %v", event.Type, event.Keys, tt.keys)
+			}
+			if event.Duration < tt.minDuration {
+				t.Errorf("%v event duration = %v, want at least %v", event.Type, event.Duration, tt.minDuration)
+			}
+		default:
+			t.Fatalf("no %v event", tt.typ)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected %v event", event.Type)
+	default:
+	}
+}
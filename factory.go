@@ -0,0 +1,32 @@
+package dataloaden
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// LoaderFactory creates Loaders from a shared config, e.g. a fresh Loader for every request.
+// Each Loader has its own batches and, unless LoaderConfig.Cache is set, its own cache.
+type LoaderFactory[K comparable, V any] struct {
+	config LoaderConfig[K, V]
+
+	// mutex to prevent races on config.Rand, which seeds the Rand of every loader
+	mu sync.Mutex
+}
+
+// NewLoaderFactory creates a new LoaderFactory given the config of its loaders
+func NewLoaderFactory[K comparable, V any](config LoaderConfig[K, V]) *LoaderFactory[K, V] {
+	return &LoaderFactory[K, V]{config: config}
+}
+
+// New creates a new Loader. When the config has a Rand, the Loader gets a Rand of its own seeded from it,
+// since loaders don't share one.
+func (f *LoaderFactory[K, V]) New() *Loader[K, V] {
+	config := f.config
+	if config.Rand != nil {
+		f.mu.Lock()
+		config.Rand = rand.New(rand.NewSource(f.config.Rand.Int63()))
+		f.mu.Unlock()
+	}
+	return NewLoader(config)
+}
@@ -0,0 +1,65 @@
+package dataloaden_test
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestLoaderFactory_New(t *testing.T) {
+	var batches int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&batches, 1)
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	factory := dataloaden.NewLoaderFactory(dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	})
+	a, b := factory.New(), factory.New()
+
+	a.Prime(1, 1000)
+	if got, _ := b.Load(1); got != 10 {
+		t.Errorf("Load() got = %v, want %v", got, 10)
+	}
+
+	thunkA, thunkB := a.LoadThunk(2), b.LoadThunk(2)
+	thunkA()
+	thunkB()
+	if got := atomic.LoadInt32(&batches); got != 3 {
+		t.Errorf("fetch called %v times, want %v", got, 3)
+	}
+}
+
+func TestLoaderFactory_Rand(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	factory := dataloaden.NewLoaderFactory(dataloaden.LoaderConfig[int, int]{
+		Fetch:      fetch,
+		Wait:       1 * time.Millisecond,
+		WaitJitter: 1 * time.Millisecond,
+		Rand:       rand.New(rand.NewSource(42)),
+	})
+
+	// loaders created and used concurrently don't race on the Rand
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := factory.New().Load(i); err != nil {
+				t.Errorf("Load() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,102 @@
+package dataloaden
+
+// secondaryIndex maps index keys to the keys of the cached values they index, see LoaderConfig.IndexFunc.
+// the zero value is an empty index.
+type secondaryIndex[K comparable] struct {
+	// lazily created keys of the cached values by index key
+	keys map[K]map[K]struct{}
+
+	// lazily created index keys of the cached value at key
+	indexes map[K][]K
+}
+
+// add indexes the cached value at key by indexes
+func (ix *secondaryIndex[K]) add(key K, indexes []K) {
+	if len(indexes) == 0 {
+		return
+	}
+	if ix.keys == nil {
+		ix.keys = map[K]map[K]struct{}{}
+		ix.indexes = map[K][]K{}
+	}
+	ix.indexes[key] = indexes
+	for _, index := range indexes {
+		if ix.keys[index] == nil {
+			ix.keys[index] = map[K]struct{}{}
+		}
+		ix.keys[index][key] = struct{}{}
+	}
+}
+
+// remove forgets the index keys of the cached value at key
+func (ix *secondaryIndex[K]) remove(key K) {
+	for _, index := range ix.indexes[key] {
+		delete(ix.keys[index], key)
+		if len(ix.keys[index]) == 0 {
+			delete(ix.keys, index)
+		}
+	}
+	delete(ix.indexes, key)
+}
+
+// lookup returns the keys of the cached values indexed by index
+func (ix *secondaryIndex[K]) lookup(index K) []K {
+	keys := make([]K, 0, len(ix.keys[index]))
+	for key := range ix.keys[index] {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// related returns the keys of the cached values indexed by key, or sharing an index key with the value at key
+func (ix *secondaryIndex[K]) related(key K) []K {
+	keys := ix.lookup(key)
+	for _, index := range ix.indexes[key] {
+		keys = append(keys, ix.lookup(index)...)
+	}
+	return keys
+}
+
+// unsafeIndex indexes value cached at key, see LoaderConfig.IndexFunc. l.mu must be held.
+func (l *Loader[K, V]) unsafeIndex(key K, value V) {
+	if l.indexFunc == nil {
+		return
+	}
+	l.index.remove(key)
+	indexes := l.indexFunc(value)
+	if l.normalize != nil || l.interner != nil {
+		// index keys are looked up like keys, so ClearByIndex finds them however it is passed them
+		canonical := make([]K, len(indexes))
+		for i, index := range indexes {
+			canonical[i] = l.unsafeFindKey(index)
+		}
+		indexes = canonical
+	}
+	l.index.add(key, indexes)
+}
+
+// unsafeClearRelated clears the value and error at key, and the values related to it by IndexFunc.
+// l.mu must be held.
+func (l *Loader[K, V]) unsafeClearRelated(key K) {
+	if l.indexFunc != nil {
+		for _, k := range l.index.related(key) {
+			l.unsafeDelete(k)
+			l.unsafeDeleteError(k)
+			l.unsafeRelease(k)
+		}
+	}
+	l.unsafeDelete(key)
+	l.unsafeDeleteError(key)
+	l.unsafeRelease(key)
+}
+
+// ClearByIndex clears every value IndexFunc indexes by index, see LoaderConfig.IndexFunc
+func (l *Loader[K, V]) ClearByIndex(index K) {
+	l.mu.Lock()
+	for _, key := range l.index.lookup(l.unsafeFindKey(index)) {
+		l.unsafeDelete(key)
+		l.unsafeDeleteError(key)
+		l.unsafeRelease(key)
+	}
+	l.unlock()
+}
@@ -0,0 +1,127 @@
+package dataloaden
+
+import (
+	"context"
+	"fmt"
+)
+
+// KeyFuncLoaderConfig captures the config to create a new KeyFuncLoader
+type KeyFuncLoaderConfig[K any, C comparable, V any] struct {
+	// KeyFunc projects a key to the comparable value it is cached and deduplicated by
+	KeyFunc func(key K) C
+
+	// Fetch is a method that provides the data for the loader, see LoaderConfig.Fetch
+	Fetch func(keys []K) ([]V, []error)
+
+	// FetchContext is like Fetch but also receives the context of the batch, see LoaderConfig.FetchContext
+	FetchContext func(ctx context.Context, keys []K) ([]V, []error)
+
+	// Config configures batching and caching. its Fetch and FetchContext are ignored.
+	Config LoaderConfig[C, V]
+}
+
+// KeyFuncLoader is a Loader for keys that are not comparable.
+// Keys are cached and deduplicated by their projection through KeyFunc, so two keys with the same
+// projection are the same key: they share a cache entry and are fetched once. fetch receives the
+// first of those keys that was added to the batch.
+type KeyFuncLoader[K any, C comparable, V any] struct {
+	loader  *Loader[C, V]
+	keyFunc func(key K) C
+}
+
+// batchKey is the context key of the batch a fetch context was created for
+type batchKey struct{}
+
+// NewKeyFuncLoader creates a new KeyFuncLoader given a key func and a fetch
+func NewKeyFuncLoader[K any, C comparable, V any](config KeyFuncLoaderConfig[K, C, V]) *KeyFuncLoader[K, C, V] {
+	fetch := config.FetchContext
+	if fetch == nil && config.Fetch != nil {
+		fetch = func(_ context.Context, keys []K) ([]V, []error) {
+			return config.Fetch(keys)
+		}
+	}
+	l := &KeyFuncLoader[K, C, V]{
+		keyFunc: config.KeyFunc,
+	}
+	inner := config.Config
+	inner.Fetch = nil
+	inner.FetchContext = func(ctx context.Context, projections []C) ([]V, []error) {
+		// the keys stay with their batch, so a retry or a refresh fetching projections again finds them too
+		b, _ := ctx.Value(batchKey{}).(*loaderBatch[C, V])
+		keys := make([]K, len(projections))
+		for i, p := range projections {
+			key, ok := b.origins[p]
+			if !ok {
+				return nil, []error{fmt.Errorf("dataloaden: no key loaded for %v", p)}
+			}
+			keys[i], _ = key.(K)
+		}
+		return fetch(ctx, keys)
+	}
+	l.loader = NewLoader(inner)
+	return l
+}
+
+// Load a V by key, batching and caching will be applied automatically
+func (l *KeyFuncLoader[K, C, V]) Load(key K) (V, error) {
+	return l.LoadThunk(key)()
+}
+
+// LoadContext is like Load but takes a context, see Loader.LoadThunkContext.
+func (l *KeyFuncLoader[K, C, V]) LoadContext(ctx context.Context, key K) (V, error) {
+	return l.LoadThunkContext(ctx, key)()
+}
+
+// LoadThunk returns a function that when called will block waiting for a V, see Loader.LoadThunk.
+func (l *KeyFuncLoader[K, C, V]) LoadThunk(key K) func() (V, error) {
+	return l.LoadThunkContext(context.Background(), key)
+}
+
+// LoadThunkContext is like LoadThunk but takes a context, see Loader.LoadThunkContext.
+func (l *KeyFuncLoader[K, C, V]) LoadThunkContext(ctx context.Context, key K) func() (V, error) {
+	// the projection is recorded as the batch has it, which Normalize or Equal may have changed
+	return dropFound(l.loader.loadThunk(ctx, l.keyFunc(key), func(b *loaderBatch[C, V], p C) {
+		if b.origins == nil {
+			b.origins = map[C]any{}
+		}
+		if _, ok := b.origins[p]; !ok {
+			b.origins[p] = key
+		}
+	}))
+}
+
+// LoadAll fetches many keys at once, see Loader.LoadAll.
+func (l *KeyFuncLoader[K, C, V]) LoadAll(keys []K) ([]V, []error) {
+	return l.LoadAllThunk(keys)()
+}
+
+// LoadAllThunk returns a function that when called will block waiting for a Vs, see Loader.LoadAllThunk.
+func (l *KeyFuncLoader[K, C, V]) LoadAllThunk(keys []K) func() ([]V, []error) {
+	results := make([]func() (V, error), len(keys))
+	for i, key := range keys {
+		results[i] = l.LoadThunk(key)
+	}
+	return func() ([]V, []error) {
+		vs := make([]V, len(keys))
+		errors := make([]error, len(keys))
+		for i, thunk := range results {
+			vs[i], errors[i] = thunk()
+		}
+		return vs, errors
+	}
+}
+
+// Prime the cache with the provided key and value, see Loader.Prime.
+func (l *KeyFuncLoader[K, C, V]) Prime(key K, value V) bool {
+	return l.loader.Prime(l.keyFunc(key), value)
+}
+
+// Clear the value at key from the cache, if it exists
+func (l *KeyFuncLoader[K, C, V]) Clear(key K) {
+	l.loader.Clear(l.keyFunc(key))
+}
+
+// ClearAll removes every value from the cache, see Loader.ClearAll.
+func (l *KeyFuncLoader[K, C, V]) ClearAll() {
+	l.loader.ClearAll()
+}
@@ -0,0 +1,223 @@
+package dataloaden_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+type tagsKey struct {
+	tags []string
+}
+
+func TestKeyFuncLoader_Load(t *testing.T) {
+	var fetched [][]string
+	fetch := func(keys []tagsKey) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i := range keys {
+			fetched = append(fetched, keys[i].tags)
+			ret[i] = len(keys[i].tags)
+		}
+		return ret, nil
+	}
+	config := dataloaden.KeyFuncLoaderConfig[tagsKey, string, int]{
+		KeyFunc: func(key tagsKey) string { return strings.Join(key.tags, ",") },
+		Fetch:   fetch,
+		Config: dataloaden.LoaderConfig[string, int]{
+			Wait: 1 * time.Millisecond,
+		},
+	}
+	loader := dataloaden.NewKeyFuncLoader(config)
+
+	got, errs := loader.LoadAll([]tagsKey{
+		{tags: []string{"a", "b"}},
+		{tags: []string{"c"}},
+		{tags: []string{"a", "b"}},
+	})
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("LoadAll() error = %v", err)
+		}
+	}
+	if want := []int{2, 1, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if want := [][]string{{"a", "b"}, {"c"}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+
+	if got, _ := loader.Load(tagsKey{tags: []string{"c"}}); got != 1 {
+		t.Errorf("Load() got = %v, want %v", got, 1)
+	}
+	if want := 2; len(fetched) != want {
+		t.Errorf("fetched %v, want %v keys", fetched, want)
+	}
+}
+
+func TestKeyFuncLoader_Normalize(t *testing.T) {
+	var fetched [][]string
+	fetch := func(keys []tagsKey) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i := range keys {
+			fetched = append(fetched, keys[i].tags)
+			ret[i] = len(keys[i].tags)
+		}
+		return ret, nil
+	}
+	config := dataloaden.KeyFuncLoaderConfig[tagsKey, string, int]{
+		KeyFunc: func(key tagsKey) string { return strings.Join(key.tags, ",") },
+		Fetch:   fetch,
+		Config: dataloaden.LoaderConfig[string, int]{
+			Wait:      1 * time.Millisecond,
+			Normalize: strings.ToLower,
+		},
+	}
+	loader := dataloaden.NewKeyFuncLoader(config)
+
+	// both keys normalize to the same projection, so the first one is fetched for both
+	got, errs := loader.LoadAll([]tagsKey{
+		{tags: []string{"A", "b"}},
+		{tags: []string{"a", "B"}},
+	})
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("LoadAll() error = %v", err)
+		}
+	}
+	if want := []int{2, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if want := [][]string{{"A", "b"}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestKeyFuncLoader_Refetch(t *testing.T) {
+	key := tagsKey{tags: []string{"a", "b"}}
+	tests := []struct {
+		name   string
+		config dataloaden.LoaderConfig[string, int]
+		// loads key, returning how many times it should have been fetched
+		load func(loader *dataloaden.KeyFuncLoader[tagsKey, string, int], clock *fakeClock) int
+	}{
+		{
+			name:   "retry",
+			config: dataloaden.LoaderConfig[string, int]{MaxRetries: 2},
+			load: func(loader *dataloaden.KeyFuncLoader[tagsKey, string, int], _ *fakeClock) int {
+				if got, err := loader.Load(key); err != nil || got != 2 {
+					t.Errorf("Load() got = %v, %v, want %v, %v", got, err, 2, nil)
+				}
+				return 2
+			},
+		},
+		{
+			name:   "refresh-ahead",
+			config: dataloaden.LoaderConfig[string, int]{TTL: 10 * time.Millisecond, RefreshAhead: 5 * time.Millisecond},
+			load: func(loader *dataloaden.KeyFuncLoader[tagsKey, string, int], clock *fakeClock) int {
+				loader.Prime(key, 2)
+				clock.Advance(6 * time.Millisecond)
+				if got, err := loader.Load(key); err != nil || got != 2 {
+					t.Errorf("Load() got = %v, %v, want %v, %v", got, err, 2, nil)
+				}
+				return 1
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var fetched [][]string
+			fetch := func(keys []tagsKey) ([]int, []error) {
+				mu.Lock()
+				defer mu.Unlock()
+				for _, key := range keys {
+					fetched = append(fetched, key.tags)
+				}
+				if len(fetched) == 1 && tt.name == "retry" {
+					return nil, []error{errors.New("unavailable")}
+				}
+				ret := make([]int, len(keys))
+				for i := range keys {
+					ret[i] = len(keys[i].tags)
+				}
+				return ret, nil
+			}
+			// retries wait for RetryBackoff on the clock, so only the refresh uses a fake one
+			clock := newFakeClock()
+			if tt.config.TTL > 0 {
+				tt.config.Clock = clock
+			}
+			loader := dataloaden.NewKeyFuncLoader(dataloaden.KeyFuncLoaderConfig[tagsKey, string, int]{
+				KeyFunc: func(key tagsKey) string { return strings.Join(key.tags, ",") },
+				Fetch:   fetch,
+				Config:  tt.config,
+			})
+
+			n := tt.load(loader, clock)
+			// refreshes are fetched in the background
+			for i := 0; i < 1000; i++ {
+				mu.Lock()
+				done := len(fetched) >= n
+				mu.Unlock()
+				if done {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			// a later fetch must not hang
+			if got, err := loader.Load(tagsKey{tags: []string{"c"}}); err != nil || got != 1 {
+				t.Errorf("Load() got = %v, %v, want %v, %v", got, err, 1, nil)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			want := make([][]string, n)
+			for i := range want {
+				want[i] = key.tags
+			}
+			want = append(want, []string{"c"})
+			if !reflect.DeepEqual(fetched, want) {
+				t.Errorf("fetched %v, want %v", fetched, want)
+			}
+		})
+	}
+}
+
+func TestKeyFuncLoader_MaxPendingBatches(t *testing.T) {
+	fetch := func(keys []tagsKey) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = len(keys[i].tags)
+		}
+		return ret, nil
+	}
+	config := dataloaden.KeyFuncLoaderConfig[tagsKey, string, int]{
+		KeyFunc: func(key tagsKey) string { return strings.Join(key.tags, ",") },
+		Fetch:   fetch,
+		Config: dataloaden.LoaderConfig[string, int]{
+			Wait:              1 * time.Millisecond,
+			MaxBatch:          1,
+			MaxPendingBatches: 1,
+		},
+	}
+	loader := dataloaden.NewKeyFuncLoader(config)
+
+	// the second load blocks until the first batch is fetched, which must not need the loader
+	done := make(chan []int)
+	go func() {
+		got, _ := loader.LoadAll([]tagsKey{{tags: []string{"a"}}, {tags: []string{"b", "c"}}})
+		done <- got
+	}()
+	select {
+	case got := <-done:
+		if want := []int{1, 2}; !reflect.DeepEqual(got, want) {
+			t.Errorf("LoadAll() got = %v, want %v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("LoadAll() deadlocked")
+	}
+}
@@ -0,0 +1,120 @@
+package dataloaden
+
+// keyInterner maps every key to the first key loaded that is equal to it by a custom equality,
+// so keys can be compared with == and used in maps like any other. keys are bucketed by hash.
+type keyInterner[K comparable] struct {
+	equal func(a, b K) bool
+	hash  func(K) uint64
+
+	// lazily created canonical keys by hash
+	buckets map[uint64][]K
+}
+
+// find returns the canonical key equal to key, if there is one
+func (in *keyInterner[K]) find(key K) (K, bool) {
+	for _, k := range in.buckets[in.hash(key)] {
+		if in.equal(k, key) {
+			return k, true
+		}
+	}
+	return key, false
+}
+
+// intern returns the canonical key equal to key, making key canonical if there is none
+func (in *keyInterner[K]) intern(key K) K {
+	if k, ok := in.find(key); ok {
+		return k
+	}
+	if in.buckets == nil {
+		in.buckets = map[uint64][]K{}
+	}
+	h := in.hash(key)
+	in.buckets[h] = append(in.buckets[h], key)
+	return key
+}
+
+// remove forgets the canonical key, so the next key equal to it becomes canonical instead
+func (in *keyInterner[K]) remove(key K) {
+	h := in.hash(key)
+	bucket := in.buckets[h]
+	for i, k := range bucket {
+		if k == key {
+			bucket[i] = bucket[len(bucket)-1]
+			bucket = bucket[:len(bucket)-1]
+			break
+		}
+	}
+	if len(bucket) == 0 {
+		delete(in.buckets, h)
+	} else {
+		in.buckets[h] = bucket
+	}
+}
+
+// unsafeIntern returns the canonical key equal to key once normalized, see LoaderConfig.Normalize
+// and LoaderConfig.Equal. l.mu must be held for writing.
+func (l *Loader[K, V]) unsafeIntern(key K) K {
+	if l.normalize != nil {
+		key = l.normalize(key)
+	}
+	if l.interner == nil {
+		return key
+	}
+	return l.interner.intern(key)
+}
+
+// unsafeFindKey is like unsafeIntern but doesn't remember new keys, so l.mu may only be held for reading
+func (l *Loader[K, V]) unsafeFindKey(key K) K {
+	if l.normalize != nil {
+		key = l.normalize(key)
+	}
+	if l.interner == nil {
+		return key
+	}
+	k, _ := l.interner.find(key)
+	return k
+}
+
+// unsafeRelease forgets the canonical key once it is neither cached nor being loaded, so the
+// keys the loader remembers don't outgrow its cache. l.mu must be held for writing.
+func (l *Loader[K, V]) unsafeRelease(key K) {
+	if l.interner == nil {
+		return
+	}
+	if _, ok := l.inflight[key]; ok {
+		return
+	}
+	if _, ok := l.computing[key]; ok {
+		return
+	}
+	if _, ok := l.errs[key]; ok {
+		return
+	}
+	if l.batch != nil && l.batch.contains(key) {
+		return
+	}
+	if _, ok := l.cache.Get(key); ok {
+		return
+	}
+	l.interner.remove(key)
+}
+
+// unsafeReleaseAll forgets every canonical key that isn't being loaded, once the cache is emptied.
+// l.mu must be held for writing.
+func (l *Loader[K, V]) unsafeReleaseAll() {
+	if l.interner == nil {
+		return
+	}
+	l.interner.buckets = nil
+	if l.batch != nil {
+		for _, key := range l.batch.keys {
+			l.interner.intern(key)
+		}
+	}
+	for key := range l.inflight {
+		l.interner.intern(key)
+	}
+	for key := range l.computing {
+		l.interner.intern(key)
+	}
+}
@@ -0,0 +1,142 @@
+package dataloaden_test
+
+import (
+	"hash/fnv"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestLoader_Equal(t *testing.T) {
+	var mu sync.Mutex
+	var fetched [][]string
+	fetch := func(keys []string) ([]string, []error) {
+		mu.Lock()
+		fetched = append(fetched, keys)
+		mu.Unlock()
+		ret := make([]string, len(keys))
+		for i, key := range keys {
+			ret[i] = "user " + strings.ToLower(key)
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[string, string]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+		Equal: strings.EqualFold,
+		Hash: func(key string) uint64 {
+			h := fnv.New64a()
+			h.Write([]byte(strings.ToLower(key)))
+			return h.Sum64()
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime("B@example.com", "primed")
+
+	got, _ := loader.LoadAll([]string{"A@example.com", "a@EXAMPLE.com", "b@example.com"})
+	if want := []string{"user a@example.com", "user a@example.com", "primed"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if got, _ := loader.Load("a@example.COM"); got != "user a@example.com" {
+		t.Errorf("Load() got = %v, want %v", got, "user a@example.com")
+	}
+	if !loader.Has("A@EXAMPLE.COM") {
+		t.Errorf("Has() got = %v, want %v", false, true)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := [][]string{{"A@example.com"}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_EqualForgetsKeys(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	fetch := func(keys []string) ([]string, []error) {
+		mu.Lock()
+		fetched = append(fetched, keys...)
+		mu.Unlock()
+		return keys, nil
+	}
+	config := dataloaden.LoaderConfig[string, string]{
+		Fetch:        fetch,
+		Wait:         1 * time.Millisecond,
+		MaxCacheSize: 1,
+		Equal:        strings.EqualFold,
+		Hash: func(key string) uint64 {
+			h := fnv.New64a()
+			h.Write([]byte(strings.ToLower(key)))
+			return h.Sum64()
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+
+	// once a key is cleared or evicted, the next key equal to it is the one fetched and cached
+	loader.Load("A")
+	loader.Clear("a")
+	if got, _ := loader.Load("a"); got != "a" {
+		t.Errorf("Load() got = %v, want %v", got, "a")
+	}
+	loader.Load("B")
+	if got, _ := loader.Load("A"); got != "A" {
+		t.Errorf("Load() got = %v, want %v", got, "A")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"A", "a", "B", "A"}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_Normalize(t *testing.T) {
+	var mu sync.Mutex
+	var fetched [][]string
+	fetch := func(keys []string) ([]string, []error) {
+		mu.Lock()
+		fetched = append(fetched, keys)
+		mu.Unlock()
+		ret := make([]string, len(keys))
+		for i, key := range keys {
+			ret[i] = "user " + key
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[string, string]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+		Normalize: func(key string) string {
+			return strings.ToLower(strings.TrimSpace(key))
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+
+	got, _ := loader.LoadAll([]string{"Foo", " foo", "FOO "})
+	if want := []string{"user foo", "user foo", "user foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if keys := loader.Keys(); !reflect.DeepEqual(keys, []string{"foo"}) {
+		t.Errorf("Keys() got = %v, want %v", keys, []string{"foo"})
+	}
+
+	loader.Clear("fOO")
+	if loader.Has("foo") {
+		t.Errorf("Has() got = %v, want %v", true, false)
+	}
+	loader.Prime(" Bar ", "primed")
+	if got, _ := loader.Load("bar"); got != "primed" {
+		t.Errorf("Load() got = %v, want %v", got, "primed")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := [][]string{{"foo"}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
@@ -1,61 +1,737 @@
 package dataloaden
 
 import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // LoaderConfig captures the config to create a new Loader
 type LoaderConfig[K comparable, V any] struct {
-	// Fetch is a method that provides the data for the loader
+	// Fetch is a method that provides the data for the loader.
+	// it must return either no values or one value per key, and either no errors,
+	// a single error (applied as SingleError says), or one error per key.
+	// any other shape results in ErrResultLength for every key.
+	// a key without an error counts as found when a value was returned for it, even a nil pointer,
+	// which is then cached like any other value. to tell keys that don't exist apart from nil
+	// values, use FetchExists or FetchMap, whose keys that are not found aren't cached.
 	Fetch func(keys []K) ([]V, []error)
 
-	// Wait is how long wait before sending a batch
+	// FetchContext is like Fetch but also receives the context of the batch.
+	// if both are set, FetchContext is used.
+	FetchContext func(ctx context.Context, keys []K) ([]V, []error)
+
+	// FetchExists is like FetchContext but also reports whether a value was found for each key,
+	// returning either no flags or one per key. keys that are not found resolve to the zero
+	// value and are not cached. if set, it is used over FetchContext and Fetch.
+	FetchExists func(ctx context.Context, keys []K) ([]V, []bool, []error)
+
+	// FetchMap is like Fetch but returns the values and errors by key, so they don't need to be
+	// lined up with keys. keys in neither map are not found, see MissingKeyError.
+	// it is only used when none of the other fetches is set.
+	FetchMap func(keys []K) (map[K]V, map[K]error)
+
+	// MissingKeyError is the error of keys FetchMap returned neither a value nor an error for,
+	// nil = they resolve to the zero value and are not cached.
+	MissingKeyError error
+
+	// Wait is how long wait before sending a batch. the wait starts when the first key of the batch
+	// is loaded, and is not extended by keys loaded after it.
+	// 0 = send the batch from a background goroutine right away, with no timer. that is cheaper,
+	// but keys are only batched until that goroutine runs: with GOMAXPROCS > 1 it may run in parallel,
+	// so even the keys of a single LoadAll may be split across batches. a non-zero Wait batches keys
+	// loaded within it, including those of concurrent goroutines, at the cost of latency.
+	// MaxBatch still caps the batch either way.
 	Wait time.Duration
 
+	// WaitJitter adds a random duration in [0, WaitJitter) to Wait for every batch,
+	// so loaders created together don't all send their batches at the same time
+	WaitJitter time.Duration
+
+	// Rand is where the loader's randomness, like WaitJitter, comes from, nil = a source of its own
+	// seeded with the time. it is only used holding the loader's lock, so it must not be shared with
+	// other loaders, but a LoaderFactory seeds a Rand of their own for its loaders from it.
+	Rand *rand.Rand
+
+	// MaxWait is the longest a batch waits after its first key, whatever Wait and WaitJitter add up to.
+	// 0 = no limit.
+	MaxWait time.Duration
+
+	// DebounceMode restarts the wait every time a key joins the batch, so the batch is sent once no key
+	// was loaded for Wait, instead of Wait after its first key. MaxWait, MaxBatch and MaxWeight still
+	// send it earlier, and with a Wait of 0 it makes no difference.
+	DebounceMode bool
+
 	// MaxBatch will limit the maximum number of keys to send in one batch, 0 = not limit
 	MaxBatch int
+
+	// SyncSingle fetches a batch that still has a single key on the goroutine calling its thunk,
+	// instead of waiting for Wait to pass, which takes the latency of Wait off lone loads.
+	// keys loaded by other goroutines after the thunk is called go to the next batch, while keys
+	// loaded before, like with LoadThunk or LoadAll, share the batch as usual.
+	SyncSingle bool
+
+	// BatchWeight is how heavy key is for MaxWeight, e.g. how costly it is to fetch. nil = every key weighs 1
+	BatchWeight func(key K) int
+
+	// MaxWeight limits the sum of the BatchWeight of the keys of one batch. a key that would
+	// take the batch over it sends the batch and starts a new one. 0 = no limit
+	MaxWeight int
+
+	// TTL is how long a cached value stays fresh, 0 = forever.
+	// only values stored by this loader expire.
+	TTL time.Duration
+
+	// TTLFor, if set, returns how long the value cached for key, fetched or primed, stays fresh,
+	// overriding TTL for that key. returning 0 uses TTL. see also Loader.PrimeTTL.
+	TTLFor func(key K, value V) time.Duration
+
+	// RefreshAhead is how long before a cached value expires it is refreshed: a load of a value
+	// expiring within RefreshAhead is served from the cache right away, and the key is fetched again
+	// in the background to replace it. 0 = values are only fetched again once they have expired.
+	// it only applies to values that expire, see TTL. a value primed with PrimeForce or PrimeTTL while
+	// the key is refreshed is kept, and a refresh that fails keeps the value it was refreshing.
+	RefreshAhead time.Duration
+
+	// SweepInterval is how often expired values and negative results are removed from the cache
+	// in the background, so keys that are never loaded again don't hold on to memory.
+	// 0 = expired entries are only removed when they are loaded. the sweeper stops with Stop.
+	SweepInterval time.Duration
+
+	// Cache is where the values are stored, nil = an in-memory map
+	Cache Cache[K, V]
+
+	// ByteCache is where the values are stored encoded as bytes with Marshal and Unmarshal, e.g. a
+	// serialized external cache. values that fail to encode aren't cached, and values that fail to decode
+	// are fetched again. it is used instead of Cache when set, and must be set together with the codec.
+	ByteCache Cache[K, []byte]
+	Marshal   func(value V) ([]byte, error)
+	Unmarshal func(data []byte) (V, error)
+
+	// Equal and Hash, if set, replace == for comparing keys, e.g. to treat keys differing only
+	// in case as the same. keys that are Equal must have the same Hash. the loader remembers the
+	// first key it sees of every set of Equal keys, and uses it for fetching and caching all of them,
+	// for as long as one of them is cached or being loaded.
+	Equal func(a, b K) bool
+	Hash  func(key K) uint64
+
+	// Normalize, if set, rewrites every key passed to the loader, e.g. trimming and lowercasing it,
+	// before it is cached, batched or looked up, so "Foo" and "foo" share a cache entry and a fetch.
+	// fetch, Keys, Snapshot and the callbacks see normalized keys. it runs before Equal and Hash.
+	Normalize func(key K) K
+
+	// MaxCacheSize will limit the number of values in the in-memory cache, evicting the least
+	// recently used value when it is exceeded. 0 = no limit. it is ignored when Cache or ByteCache is set.
+	MaxCacheSize int
+
+	// MaxCacheBytes limits the total SizeOf the values in the in-memory cache, evicting values like
+	// MaxCacheSize does until they fit. a value bigger than MaxCacheBytes on its own is not cached.
+	// 0 = no limit. it is ignored when Cache or ByteCache is set.
+	MaxCacheBytes int64
+
+	// SizeOf returns the size of a value for MaxCacheBytes, e.g. its approximate size in bytes.
+	// it is called once when a value is cached, so the value must not change size afterwards.
+	SizeOf func(V) int64
+
+	// EvictionPolicy is how the in-memory cache makes room once it holds MaxCacheSize values or
+	// MaxCacheBytes, the least recently used value is evicted by default. it is ignored when neither is set.
+	EvictionPolicy EvictionPolicy
+
+	// CacheCapacity is how many values the in-memory cache has room for before it grows.
+	// it is ignored when Cache is set.
+	CacheCapacity int
+
+	// CacheErrors will cache errors returned by fetch, so the key is not fetched again until it is cleared
+	CacheErrors bool
+
+	// DisableCache turns the cache off: loads are still batched, but nothing is cached, so loading
+	// a key again after its batch is done fetches it again. Prime and PrimeForce do nothing.
+	DisableCache bool
+
+	// Copy returns a deep copy of a value, nil = values are shared. when set, values are copied
+	// as they are stored in and read from the cache, so every caller gets its own copy and can't
+	// change what the cache holds. this trades an allocation per load for safety with mutable values.
+	// Snapshot returns copies too, but the predicate of ClearWhere and SizeOf are passed the cached
+	// values themselves, so they must not change them.
+	Copy func(V) V
+
+	// IndexFunc returns the secondary keys of a cached value, e.g. the email of a user cached by ID.
+	// Clear and ClearMany also clear the values indexed by the cleared key or sharing an index
+	// key with its value, and ClearByIndex clears the values indexed by a key. nil = no index
+	IndexFunc func(V) []K
+
+	// NegativeTTL is how long negative results stay cached, 0 = they are not cached unless CacheErrors is set.
+	// negative results are keys that are not found (see FetchExists) and errors IsNotFound reports.
+	// they expire after NegativeTTL even when CacheErrors is set.
+	NegativeTTL time.Duration
+
+	// IsNotFound reports whether err means the key doesn't exist, nil = no error does
+	IsNotFound func(err error) bool
+
+	// SingleError is how a single error returned by fetch for a batch of many keys is applied.
+	// the default, SingleErrorBroadcast, fails every key of the batch with it.
+	SingleError SingleErrorMode
+
+	// RequireResults fails the keys fetch returned neither a value nor an error for with ErrNoResult,
+	// instead of resolving them to the zero value. that is every key when it returns no values, and
+	// the keys past the end of the values when it returns fewer values than keys, which would
+	// otherwise fail every key with ErrResultLength. more values than keys still do.
+	RequireResults bool
+
+	// OnPanic is called with the recovered value when fetch panics.
+	// every key of the batch gets a *PanicError whether or not it is set.
+	OnPanic func(recovered any)
+
+	// FetchTimeout is how long a single fetch may take before every key of the batch fails with
+	// ErrFetchTimeout, 0 = no limit. the context passed to fetch is cancelled then, but a fetch that
+	// ignores it keeps running in the background until it returns. retries get a new timeout.
+	// a batch every caller gave up on stops waiting too, but with context.Canceled, which is not cached.
+	FetchTimeout time.Duration
+
+	// LoadTimeout is how long a thunk waits for its batch before returning ErrLoadTimeout, 0 = no limit.
+	// the batch is not cancelled, so other loads waiting on it still get its results, and they are
+	// still cached. it doesn't apply to a batch that SyncSingle fetches on the thunk's goroutine.
+	LoadTimeout time.Duration
+
+	// MaxConcurrentBatches will limit the number of batches being fetched at the same time, 0 = no limit.
+	// excess batches wait for a running one to finish. a fetch FetchTimeout gave up on still counts
+	// until it returns, and a batch waits between retries without counting.
+	MaxConcurrentBatches int
+
+	// MaxPendingBatches limits the batches collecting keys or being fetched at once, 0 = no limit.
+	// a load that would start a batch over the limit blocks until a batch is done, and if its
+	// context is done first its thunk returns ctx.Err(); with no context it may block for as long
+	// as fetch takes. loads served from the cache or joining a batch never block, and neither do
+	// the batches LoadAll splits keys into by MaxBatch, or RefreshAhead.
+	MaxPendingBatches int
+
+	// MaxRetries is how many more times a batch is fetched when it fails, 0 = no retries
+	MaxRetries int
+
+	// RetryBackoff is how long to wait before fetching a failed batch again
+	RetryBackoff time.Duration
+
+	// ShouldRetry reports whether err is worth retrying, nil = every error is.
+	// a batch is retried when any of its errors should be retried.
+	ShouldRetry func(err error) bool
+
+	// RetryFailedKeys makes a retry fetch only the keys whose errors should be retried, instead of
+	// the whole batch, and merges its results into the batch's. a single error for the whole batch
+	// still retries every key.
+	RetryFailedKeys bool
+
+	// DetectDeadlocks makes a load from inside fetch, using the context passed to fetch, fail with
+	// ErrDeadlock when it would wait for the very batch being fetched, directly or through fetches of
+	// other loaders, instead of blocking forever. loads through other contexts are not detected.
+	DetectDeadlocks bool
+
+	// KeyLess, if set, sorts the keys of every batch before they are passed to fetch, for backends
+	// that are faster with sorted keys. results are still matched up with the sorted keys, and
+	// loads resolve to the result of their own key. nil = keys are passed in the order they were loaded.
+	KeyLess func(a, b K) bool
+
+	// Dispatch, if set, is called with the keys of every batch instead of fetch, and is passed fetch
+	// to call itself, e.g. to split keys across shards and fetch them in parallel. it must return
+	// results matched up with keys like Fetch does. keys FetchExists or FetchMap didn't find stay not found.
+	Dispatch func(keys []K, fetch func([]K) ([]V, []error)) ([]V, []error)
+
+	// AfterFetch can validate or rewrite the results of every batch before they are resolved or cached.
+	// it must keep them matched up with keys like fetch does, see Fetch.
+	AfterFetch func(keys []K, values []V, errs []error) ([]V, []error)
+
+	// Clock is used to tell the time and schedule batches, nil = the real clock
+	Clock Clock
+
+	// Scheduler dispatches batches from a goroutine shared with other loaders, nil = every batch
+	// gets its own timer from Clock. see Scheduler.
+	Scheduler *Scheduler
+
+	// OnEvict is called for every value removed from the cache by Clear, ClearWhere, ClearAll,
+	// TTL expiry, or MaxCacheSize eviction. it is not called for values overwritten by PrimeForce
+	// or a fetch. it is called without holding the loader's lock, so it may use the loader.
+	OnEvict func(key K, value V)
+
+	// OnBatch is called after every batch is fetched with its keys, how long fetch took, and the errors it returned
+	OnBatch func(keys []K, duration time.Duration, err []error)
+
+	// OnBatchSize is called with the number of keys of every batch before it is fetched,
+	// e.g. to record a histogram of batch sizes
+	OnBatchSize func(size int)
+
+	// Observer is told about cache hits, misses, batches and panics, nil = nobody is.
+	// it can be used together with the On* hooks, which are called first.
+	Observer Observer[K]
+
+	// SlowFetchThreshold is how long fetching a batch may take before OnSlowFetch is called, 0 = no limit
+	SlowFetchThreshold time.Duration
+
+	// OnSlowFetch is called with the keys of every batch that took longer than SlowFetchThreshold to fetch
+	OnSlowFetch func(keys []K, duration time.Duration)
+
+	// OnResult is called with the result of every key of a batch once it is fetched, e.g. to prime another
+	// loader. it is called without holding the loader's lock, before the thunks waiting on the batch
+	// resolve, so before the values are cached by this loader. keys that are not found get the zero value.
+	OnResult func(key K, value V, err error)
+
+	// OnLoadWait is called by every thunk waiting on a batch with how long it took from creating the thunk
+	// until the batch was done, or the thunk was called if that was later. it includes both Wait and fetch.
+	OnLoadWait func(key K, waited time.Duration)
 }
 
-// NewLoader creates a new Loader given a fetch, wait, and maxBatch
+// NewLoader creates a new Loader given a fetch, wait, and maxBatch.
+// It panics if config is invalid, see NewLoaderChecked.
 func NewLoader[K comparable, V any](config LoaderConfig[K, V]) *Loader[K, V] {
-	return &Loader[K, V]{
-		fetch:    config.Fetch,
+	l, err := NewLoaderChecked(config)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// NewLoaderChecked is like NewLoader but returns an error wrapping ErrInvalidConfig
+// instead of panicking when config has no fetch, or a negative Wait or MaxBatch.
+func NewLoaderChecked[K comparable, V any](config LoaderConfig[K, V]) (*Loader[K, V], error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	fetch := config.FetchExists
+	if fetch == nil && config.FetchContext != nil {
+		fetch = func(ctx context.Context, keys []K) ([]V, []bool, []error) {
+			data, errs := config.FetchContext(ctx, keys)
+			return data, nil, errs
+		}
+	}
+	if fetch == nil && config.Fetch != nil {
+		fetch = func(_ context.Context, keys []K) ([]V, []bool, []error) {
+			data, errs := config.Fetch(keys)
+			return data, nil, errs
+		}
+	}
+	if fetch == nil && config.FetchMap != nil {
+		fetch = func(_ context.Context, keys []K) ([]V, []bool, []error) {
+			values, errs := config.FetchMap(keys)
+			return lineUp(keys, values, errs, config.MissingKeyError)
+		}
+	}
+	if config.Dispatch != nil {
+		base := fetch
+		fetch = func(ctx context.Context, keys []K) ([]V, []bool, []error) {
+			// Dispatch may fetch keys in any order and in parallel, so the keys not found are collected
+			var mu sync.Mutex
+			var missing map[K]bool
+			data, errs := config.Dispatch(keys, func(keys []K) ([]V, []error) {
+				data, found, errs := base(ctx, keys)
+				mu.Lock()
+				for i, ok := range found {
+					if !ok && i < len(keys) {
+						if missing == nil {
+							missing = map[K]bool{}
+						}
+						missing[keys[i]] = true
+					}
+				}
+				mu.Unlock()
+				return data, errs
+			})
+			if len(missing) == 0 {
+				return data, nil, errs
+			}
+			found := make([]bool, len(keys))
+			for i, key := range keys {
+				found[i] = !missing[key]
+			}
+			return data, found, errs
+		}
+	}
+	l := &Loader[K, V]{
+		fetch:    fetch,
 		wait:     config.Wait,
+		jitter:   config.WaitJitter,
+		rand:     config.Rand,
+		maxWait:  config.MaxWait,
 		maxBatch: config.MaxBatch,
+		ttl:      config.TTL,
+		cache:    config.Cache,
+
+		debounceMode: config.DebounceMode,
+
+		batchWeight: config.BatchWeight,
+		maxWeight:   config.MaxWeight,
+
+		sweepInterval: config.SweepInterval,
+		disableCache:  config.DisableCache,
+		syncSingle:    config.SyncSingle,
+		refreshAhead:  config.RefreshAhead,
+		ttlFor:        config.TTLFor,
+
+		cacheErrors: config.CacheErrors,
+		copy:        config.Copy,
+		indexFunc:   config.IndexFunc,
+		normalize:   config.Normalize,
+		negativeTTL: config.NegativeTTL,
+		isNotFound:  config.IsNotFound,
+		singleError: config.SingleError,
+		onPanic:     config.OnPanic,
+		onBatch:     config.OnBatch,
+		onBatchSize: config.OnBatchSize,
+		onSlowFetch: config.OnSlowFetch,
+		onLoadWait:  config.OnLoadWait,
+		onResult:    config.OnResult,
+		observer:    config.Observer,
+		onEvict:     config.OnEvict,
+		afterFetch:  config.AfterFetch,
+		keyLess:     config.KeyLess,
+		clock:       config.Clock,
+		scheduler:   config.Scheduler,
+
+		slowFetchThreshold: config.SlowFetchThreshold,
+		detectDeadlocks:    config.DetectDeadlocks,
+		maxPendingBatches:  config.MaxPendingBatches,
+		requireResults:     config.RequireResults,
+		retryFailedKeys:    config.RetryFailedKeys,
+
+		fetchTimeout: config.FetchTimeout,
+		loadTimeout:  config.LoadTimeout,
+		maxRetries:   config.MaxRetries,
+		retryBackoff: config.RetryBackoff,
+		shouldRetry:  config.ShouldRetry,
+	}
+	if l.clock == nil {
+		l.clock = realClock{}
+	}
+	if l.observer == nil {
+		l.observer = NoopObserver[K]{}
+	}
+	if config.Equal != nil {
+		l.interner = &keyInterner[K]{equal: config.Equal, hash: config.Hash}
+	}
+	if config.MaxConcurrentBatches > 0 {
+		l.fetching = make(chan struct{}, config.MaxConcurrentBatches)
+	}
+	if config.ByteCache != nil {
+		l.cache = &codecCache[K, V]{store: config.ByteCache, marshal: config.Marshal, unmarshal: config.Unmarshal}
+	}
+	if l.cache == nil && (config.MaxCacheSize > 0 || config.MaxCacheBytes > 0) {
+		bounded := newBoundedCache[K, V](config.MaxCacheSize, config.CacheCapacity, config.EvictionPolicy)
+		bounded.maxBytes, bounded.sizeOf = config.MaxCacheBytes, config.SizeOf
+		bounded.onEvict = func(key K, value V) {
+			delete(l.expires, key)
+			l.index.remove(key)
+			l.unsafeEvicted(key, value)
+			l.unsafeRelease(key)
+		}
+		l.cache = bounded
 	}
+	if l.cache == nil {
+		l.cache = &mapCache[K, V]{capacity: config.CacheCapacity}
+	}
+	// every cache can be read concurrently but a bounded one tracking use
+	bounded, isBounded := l.cache.(*boundedCache[K, V])
+	l.concurrentGet = !isBounded || bounded.concurrentGet()
+	if l.sweepInterval > 0 {
+		l.mu.Lock()
+		l.sweeper = l.clock.AfterFunc(l.sweepInterval, l.sweep)
+		l.mu.Unlock()
+	}
+	return l, nil
+}
+
+// validate reports an error wrapping ErrInvalidConfig if config can't make a working Loader
+func (config LoaderConfig[K, V]) validate() error {
+	if config.Fetch == nil && config.FetchContext == nil && config.FetchExists == nil && config.FetchMap == nil {
+		return fmt.Errorf("%w: one of Fetch, FetchContext, FetchExists or FetchMap must be set", ErrInvalidConfig)
+	}
+	if config.Wait < 0 {
+		return fmt.Errorf("%w: negative Wait %v", ErrInvalidConfig, config.Wait)
+	}
+	if config.MaxBatch < 0 {
+		return fmt.Errorf("%w: negative MaxBatch %d", ErrInvalidConfig, config.MaxBatch)
+	}
+	if config.MaxPendingBatches < 0 {
+		return fmt.Errorf("%w: negative MaxPendingBatches %d", ErrInvalidConfig, config.MaxPendingBatches)
+	}
+	if config.MaxWeight < 0 {
+		return fmt.Errorf("%w: negative MaxWeight %d", ErrInvalidConfig, config.MaxWeight)
+	}
+	if config.ByteCache != nil && (config.Marshal == nil || config.Unmarshal == nil) {
+		return fmt.Errorf("%w: ByteCache needs Marshal and Unmarshal", ErrInvalidConfig)
+	}
+	if (config.MaxCacheBytes == 0) != (config.SizeOf == nil) {
+		return fmt.Errorf("%w: MaxCacheBytes and SizeOf must be set together", ErrInvalidConfig)
+	}
+	if (config.Equal == nil) != (config.Hash == nil) {
+		return fmt.Errorf("%w: Equal and Hash must be set together", ErrInvalidConfig)
+	}
+	return nil
 }
 
 // Loader batches and caches requests
 type Loader[K comparable, V any] struct {
+	// counters, kept first so they are 64-bit aligned for atomic access
+	stats loaderStats
+
 	// this method provides the data for the loader
-	fetch func(keys []K) ([]V, []error)
+	fetch func(ctx context.Context, keys []K) ([]V, []bool, []error)
 
 	// how long to done before sending a batch
 	wait time.Duration
 
+	// up to how long to randomly add to wait
+	jitter time.Duration
+
+	// source of randomness, lazily created unless set
+	rand *rand.Rand
+
+	// the longest a batch waits after its first key, 0 = no limit
+	maxWait time.Duration
+
+	// whether the wait restarts every time a key joins a batch
+	debounceMode bool
+
 	// this will limit the maximum number of keys to send in one batch, 0 = no limit
 	maxBatch int
 
+	// how heavy a key is, and the most a batch may weigh, 0 = no limit
+	batchWeight func(K) int
+	maxWeight   int
+
+	// whether a batch with a single key is fetched by the first caller of its thunk
+	syncSingle bool
+
+	// how long a cached value stays fresh, 0 = forever
+	ttl time.Duration
+
+	// how often expired entries are swept, 0 = never
+	sweepInterval time.Duration
+
+	// how long before expiry values are refreshed, 0 = never
+	refreshAhead time.Duration
+
+	// how long the value of a key stays fresh, overriding ttl
+	ttlFor func(key K, value V) time.Duration
+
+	// where the values are stored
+	cache Cache[K, V]
+
+	// rewrites every key passed to the loader, nil = keys are used as they are
+	normalize func(K) K
+
+	// maps keys to a canonical one when keys have a custom equality, nil = they don't
+	interner *keyInterner[K]
+
+	// whether errors returned by fetch are cached
+	cacheErrors bool
+
+	// deep copies a value, nil = values are shared
+	copy func(V) V
+
+	// returns the secondary keys of a value, nil = values aren't indexed
+	indexFunc func(V) []K
+
+	// the cached values by their secondary keys
+	index secondaryIndex[K]
+
+	// lazily created calls of LoadOrStore computing a value by key
+	computing map[K]*computeCall[V]
+
+	// lazily created channel of batch events, see Events
+	events chan BatchEvent[K]
+
+	// whether nothing is cached
+	disableCache bool
+
+	// how long negative results stay cached, 0 = not cached
+	negativeTTL time.Duration
+
+	// reports whether an error means the key doesn't exist
+	isNotFound func(err error) bool
+
+	// how a single error for many keys is applied
+	singleError SingleErrorMode
+
+	// whether keys fetch returned no value nor error for fail
+	requireResults bool
+
+	// called when fetch panics
+	onPanic func(recovered any)
+
+	// called after every batch is fetched
+	onBatch func(keys []K, duration time.Duration, err []error)
+
+	// called with the size of every batch before it is fetched
+	onBatchSize func(size int)
+
+	// how long fetching a batch may take before onSlowFetch is called, 0 = no limit
+	slowFetchThreshold time.Duration
+
+	// called for every batch slower to fetch than slowFetchThreshold
+	onSlowFetch func(keys []K, duration time.Duration)
+
+	// called with how long every thunk waited on its batch
+	onLoadWait func(key K, waited time.Duration)
+
+	// called with the result of every key fetched
+	onResult func(key K, value V, err error)
+
+	// told about what the loader does, never nil
+	observer Observer[K]
+
+	// called for every value removed from the cache
+	onEvict func(key K, value V)
+
+	// rewrites the results of every batch
+	afterFetch func(keys []K, values []V, errs []error) ([]V, []error)
+
+	// sorts the keys passed to fetch, nil = they aren't sorted
+	keyLess func(a, b K) bool
+
+	// whether loads from inside fetch waiting on their own batch fail instead of blocking
+	detectDeadlocks bool
+
+	// tells the time and schedules batches
+	clock Clock
+
+	// schedules batches instead of clock, if set
+	scheduler *Scheduler
+
+	// how long a single fetch may take, 0 = no limit
+	fetchTimeout time.Duration
+
+	// how long a thunk waits for its batch, 0 = no limit
+	loadTimeout time.Duration
+
+	// how many more times a failed batch is fetched
+	maxRetries int
+
+	// how long to wait between retries
+	retryBackoff time.Duration
+
+	// reports whether an error is worth retrying, nil = every error is
+	shouldRetry func(err error) bool
+
+	// whether retries only fetch the keys that failed
+	retryFailedKeys bool
+
 	// INTERNAL
 
-	// lazily created cache
-	cache map[K]V
+	// lazily created cache of errors and negative results, the latter stored as nil errors
+	errs map[K]error
+
+	// lazily created expiry times of the cached negative results
+	errExpires map[K]time.Time
+
+	// values removed from the cache while holding mu, waiting for onEvict
+	evicted []cacheEntry[K, V]
+
+	// semaphore limiting the number of concurrent fetches, nil = no limit
+	fetching chan struct{}
+
+	// the most batches collecting keys or being fetched at once, 0 = no limit
+	maxPendingBatches int
+
+	// number of batches closed to new keys that aren't done yet
+	closedBatches int
+
+	// lazily created channel closed when a batch is done, waking loads waiting for maxPendingBatches
+	batchDone chan struct{}
+
+	// lazily created expiry times of the cached values that expire
+	expires map[K]time.Time
 
 	// the current batch. keys will continue to be collected until timeout is hit,
 	// then everything will be sent to the fetch method and out to the listeners
 	batch *loaderBatch[K, V]
 
-	// mutex to prevent races
-	mu sync.Mutex
+	// lazily created index of the keys of the batches being fetched
+	inflight map[K]inflightKey[K, V]
+
+	// lazily created set of the keys being refreshed ahead of expiry
+	refreshing map[K]bool
+
+	// runs the next sweep, nil = not sweeping
+	sweeper Timer
+
+	// number of batches being fetched, updated atomically
+	activeBatches int32
+
+	// set once the loader is stopped
+	closed bool
+
+	// bumped by Reset, so what batches and computes started before it return is not cached
+	generation int
+
+	// whether cache.Get may be called holding only a read lock of mu
+	concurrentGet bool
+
+	// mutex to prevent races. cache hits only take a read lock, so they don't contend
+	mu sync.RWMutex
+}
+
+// inflightKey is the position of a key in a batch being fetched
+type inflightKey[K comparable, V any] struct {
+	batch *loaderBatch[K, V]
+	pos   int
 }
 
 type loaderBatch[K comparable, V any] struct {
 	keys    []K
 	data    []V
+	found   []bool
 	error   []error
 	closing bool
 	done    chan struct{}
+
+	// the maxBatch of the loader when the batch was created
+	maxBatch int
+
+	// the sum of the weights of keys, see LoaderConfig.MaxWeight
+	weight int
+
+	// dispatches the batch once wait has passed
+	timer Timer
+
+	// when timer is due
+	dispatchAt time.Time
+
+	// the wait of the batch, and the latest it is sent whatever keys join it, only used in DebounceMode
+	wait     time.Duration
+	deadline time.Time
+
+	// the context passed to fetch. it is cancelled once every caller waiting on
+	// the batch has cancelled its own context.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// number of contexts of callers that have not been cancelled yet
+	waiting int
+
+	// lazily created set of the Done channels of the contexts of callers, so callers sharing a
+	// context, like the loads of LoadAllContext, are watched by a single goroutine
+	watched map[<-chan struct{}]struct{}
+
+	// set when a caller joined with a context that can never be cancelled
+	detached bool
+
+	// lazily created set of the batches being fetched up the chain of fetches of the callers,
+	// only used when detecting deadlocks
+	callers map[any]struct{}
+
+	// where events of the batch are sent, and when it was opened. nil = nobody subscribed
+	events chan<- BatchEvent[K]
+	opened time.Time
+
+	// lazily created keys loaded for the keys of the batch, when they differ, see KeyFuncLoader
+	origins map[K]any
+
+	// the generation of the loader when the batch was created, see Loader.Reset
+	generation int
 }
 
 // Load a V by key, batching and caching will be applied automatically
@@ -63,144 +739,1776 @@ func (l *Loader[K, V]) Load(key K) (V, error) {
 	return l.LoadThunk(key)()
 }
 
+// LoadContext is like Load but takes a context, see LoadThunkContext.
+func (l *Loader[K, V]) LoadContext(ctx context.Context, key K) (V, error) {
+	return l.LoadThunkContext(ctx, key)()
+}
+
 // LoadThunk returns a function that when called will block waiting for a V.
 // This method should be used if you want one goroutine to make requests to many
 // different data loaders without blocking until the thunk is called.
 func (l *Loader[K, V]) LoadThunk(key K) func() (V, error) {
-	l.mu.Lock()
-	if it, ok := l.cache[key]; ok {
-		l.mu.Unlock()
-		return func() (V, error) {
-			return it, nil
-		}
-	}
-	if l.batch == nil {
-		l.batch = &loaderBatch[K, V]{done: make(chan struct{})}
-	}
-	batch := l.batch
-	pos := batch.keyIndex(l, key)
-	l.mu.Unlock()
-
-	return func() (V, error) {
-		<-batch.done
+	return l.LoadThunkContext(context.Background(), key)
+}
 
-		var data V
-		if pos < len(batch.data) {
-			data = batch.data[pos]
-		}
+// LoadThunkContext is like LoadThunk but takes a context.
+// The returned thunk stops waiting and returns ctx.Err() when ctx is done.
+//
+// A batch is shared by many callers, so the context passed to fetch is not derived
+// from any single caller's context: it carries no values and is cancelled only
+// after every caller waiting on the batch has cancelled its context. When that
+// happens the batch is dispatched right away.
+//
+// If ctx has a deadline before the batch would be sent, the batch is sent right away
+// with the keys collected so far, and later keys start a new batch. MaxBatch still
+// caps the batch, it is only ever sent earlier.
+func (l *Loader[K, V]) LoadThunkContext(ctx context.Context, key K) func() (V, error) {
+	return dropFound(l.loadThunk(ctx, key, nil))
+}
 
-		var err error
-		// its convenient to be able to return a single error for everything
-		if len(batch.error) == 1 {
-			err = batch.error[0]
-		} else if batch.error != nil {
-			err = batch.error[pos]
-		}
+// LoadThunkCached is like LoadThunk but also reports whether the key was served from the
+// cache, in which case the thunk resolves right away without waiting for a batch.
+func (l *Loader[K, V]) LoadThunkCached(key K) (func() (V, error), bool) {
+	thunk, cached := l.loadThunkCached(context.Background(), key, nil)
+	return dropFound(thunk), cached
+}
 
-		if err == nil {
-			l.mu.Lock()
-			l.unsafeSet(key, data)
-			l.mu.Unlock()
-		}
+// LoadExists is like Load but also reports whether a value was found for key.
+// Cached values are always found, see LoaderConfig.FetchExists for fetched ones.
+func (l *Loader[K, V]) LoadExists(key K) (V, bool, error) {
+	return l.loadThunk(context.Background(), key, nil)()
+}
 
-		return data, err
+// dropFound turns a thunk from loadThunk into one from LoadThunk
+func dropFound[V any](thunk func() (V, bool, error)) func() (V, error) {
+	return func() (V, error) {
+		v, _, err := thunk()
+		return v, err
 	}
 }
 
-// LoadAll fetches many keys at once. It will be broken into appropriate sized
-// sub batches depending on how the loader is configured
-func (l *Loader[K, V]) LoadAll(keys []K) ([]V, []error) {
-	return l.LoadAllThunk(keys)()
+// loadThunk implements LoadThunkContext, also reporting whether a value was found.
+// added, if not nil, is called with l.mu held with the batch key joins and the key as it is in
+// the batch, after Normalize and Equal, before the batch can be sent.
+func (l *Loader[K, V]) loadThunk(ctx context.Context, key K, added func(b *loaderBatch[K, V], key K)) func() (V, bool, error) {
+	thunk, _ := l.loadThunkCached(ctx, key, added)
+	return thunk
 }
 
-// LoadAllThunk returns a function that when called will block waiting for a Vs.
-// This method should be used if you want one goroutine to make requests to many
-// different data loaders without blocking until the thunk is called.
-func (l *Loader[K, V]) LoadAllThunk(keys []K) func() ([]V, []error) {
-	results := make([]func() (V, error), len(keys))
-	for i, key := range keys {
-		results[i] = l.LoadThunk(key)
+// loadThunkCached implements loadThunk, also reporting whether key was served from the cache.
+func (l *Loader[K, V]) loadThunkCached(ctx context.Context, key K, added func(b *loaderBatch[K, V], key K)) (func() (V, bool, error), bool) {
+	if thunk, ok := l.loadCached(key); ok {
+		l.countLoad(key, true)
+		return thunk, true
 	}
-	return func() ([]V, []error) {
-		vs := make([]V, len(keys))
-		errors := make([]error, len(keys))
-		for i, thunk := range results {
-			vs[i], errors[i] = thunk()
+
+	l.mu.Lock()
+	for !l.closed && l.unsafeOverPending(key) {
+		if l.batchDone == nil {
+			l.batchDone = make(chan struct{})
 		}
-		return vs, errors
+		done := l.batchDone
+		l.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			err := ctx.Err()
+			return func() (V, bool, error) {
+				var zero V
+				return zero, false, err
+			}, false
+		}
+		l.mu.Lock()
+	}
+	if l.closed {
+		l.mu.Unlock()
+		return closedThunk[V], false
 	}
+	thunk, cached := l.unsafeLoadThunk(ctx, key, added)
+	l.unlock()
+	l.countLoad(key, cached)
+	return thunk, cached
 }
 
-// Prime the cache with the provided key and value. If the key already exists, no change is made
-// and false is returned.
-// (To forcefully prime the cache, clear the key first with loader.clear(key).prime(key, value).)
-func (l *Loader[K, V]) Prime(key K, value V) bool {
-	l.mu.Lock()
-	var found bool
-	if _, found = l.cache[key]; !found {
-		l.unsafeSet(key, value)
+// unsafeOverPending reports whether loading key would start a batch over maxPendingBatches.
+// l.mu must be held.
+func (l *Loader[K, V]) unsafeOverPending(key K) bool {
+	if l.maxPendingBatches <= 0 || l.batch != nil || l.closedBatches < l.maxPendingBatches {
+		return false
 	}
-	l.mu.Unlock()
-	return !found
+	key = l.unsafeFindKey(key)
+	if !l.disableCache {
+		if _, ok := l.unsafeGet(key); ok {
+			return false
+		}
+		if ok, _ := l.unsafeGetError(key); ok {
+			return false
+		}
+	}
+	in, ok := l.inflight[key]
+	return !ok || in.batch.ctx.Err() != nil
 }
 
-// Clear the value at key from the cache, if it exists
-func (l *Loader[K, V]) Clear(key K) {
-	l.mu.Lock()
-	delete(l.cache, key)
-	l.mu.Unlock()
+// unsafeWakePending wakes the loads waiting for a batch to be done. l.mu must be held.
+func (l *Loader[K, V]) unsafeWakePending() {
+	if l.batchDone != nil {
+		close(l.batchDone)
+		l.batchDone = nil
+	}
 }
 
-func (l *Loader[K, V]) unsafeSet(key K, value V) {
-	if l.cache == nil {
-		l.cache = map[K]V{}
-	}
-	l.cache[key] = value
+// closedThunk is the thunk of every load from a stopped loader
+func closedThunk[V any]() (V, bool, error) {
+	var zero V
+	return zero, false, ErrClosed
 }
 
-// keyIndex will return the location of the key in the batch, if its not found
-// it will add the key to the batch
-func (b *loaderBatch[K, V]) keyIndex(l *Loader[K, V], key K) int {
-	for i, existingKey := range b.keys {
-		if key == existingKey {
-			return i
+// unsafeLoadThunk is the slow path of loadThunkCached, serving key from the cache or adding it
+// to l.batch. l.mu must be held, and the loader must not be closed.
+func (l *Loader[K, V]) unsafeLoadThunk(ctx context.Context, key K, added func(b *loaderBatch[K, V], key K)) (func() (V, bool, error), bool) {
+	key = l.unsafeIntern(key)
+	if it, ok := l.unsafeGet(key); ok && !l.disableCache {
+		if l.unsafeNeedsRefresh(key) {
+			l.unsafeRefresh(key, added)
 		}
+		it = l.copyValue(it)
+		return func() (V, bool, error) {
+			return it, true, nil
+		}, true
 	}
-
-	pos := len(b.keys)
-	b.keys = append(b.keys, key)
-	if pos == 0 {
-		go b.startTimer(l)
+	if ok, err := l.unsafeGetError(key); ok && !l.disableCache {
+		return func() (V, bool, error) {
+			var zero V
+			return zero, false, err
+		}, true
 	}
-
-	if l.maxBatch != 0 && pos >= l.maxBatch-1 {
-		if !b.closing {
-			b.closing = true
-			l.batch = nil
-			go b.end(l)
+	if in, ok := l.inflight[key]; ok && in.batch.ctx.Err() == nil {
+		if l.detectDeadlocks && deadlocked(ctx, in.batch) {
+			return func() (V, bool, error) {
+				var zero V
+				return zero, false, ErrDeadlock
+			}, false
 		}
+		// the key is already being fetched, so wait for that instead of fetching it again
+		in.batch.watch(l, ctx)
+		return l.batchThunk(ctx, in.batch, key, in.pos), false
+	}
+	batch := l.unsafePendingBatch(key)
+	if added != nil {
+		added(batch, key)
 	}
+	pos := batch.keyIndex(l, key)
+	batch.watch(l, ctx)
+	return l.batchThunk(ctx, batch, key, pos), false
+}
 
-	return pos
+// countLoad updates the stats and tells the observer about a load of key, which was either
+// served from the cache or had to wait for a batch
+func (l *Loader[K, V]) countLoad(key K, cached bool) {
+	if cached {
+		atomic.AddInt64(&l.stats.cacheHits, 1)
+		l.observer.CacheHit(key)
+		return
+	}
+	atomic.AddInt64(&l.stats.cacheMisses, 1)
+	l.observer.CacheMiss(key)
 }
 
-func (b *loaderBatch[K, V]) startTimer(l *Loader[K, V]) {
-	time.Sleep(l.wait)
+// loadChunks loads keys in batches of their own of up to maxBatch keys each, which are sent
+// right away instead of waiting for other keys to join them
+func (l *Loader[K, V]) loadChunks(ctx context.Context, keys []K) []func() (V, error) {
+	thunks := make([]func() (V, error), len(keys))
+	cached := make([]bool, len(keys))
 	l.mu.Lock()
-
-	// we must have hit a batch limit and are already finalizing this batch
-	if b.closing {
+	if l.closed {
 		l.mu.Unlock()
-		return
+		for i := range thunks {
+			thunks[i] = dropFound(closedThunk[V])
+		}
+		return thunks
 	}
-
+	// set the batch collecting keys aside, so the keys don't join it
+	pending := l.batch
 	l.batch = nil
-	l.mu.Unlock()
-
-	b.end(l)
-}
+	for i, key := range keys {
+		var thunk func() (V, bool, error)
+		thunk, cached[i] = l.unsafeLoadThunk(ctx, key, nil)
+		thunks[i] = dropFound(thunk)
+	}
+	// full batches are already sent by keyIndex, which leaves the last one
+	if l.batch != nil {
+		l.batch.dispatch(l)
+	}
+	l.batch = pending
+	l.unlock()
+
+	for i, key := range keys {
+		l.countLoad(key, cached[i])
+	}
+	return thunks
+}
+
+// batchThunk returns a thunk resolving to the result of key at pos in batch, and caching it
+func (l *Loader[K, V]) batchThunk(ctx context.Context, batch *loaderBatch[K, V], key K, pos int) func() (V, bool, error) {
+	var created time.Time
+	if l.onLoadWait != nil {
+		created = l.clock.Now()
+	}
+	return func() (V, bool, error) {
+		if l.syncSingle {
+			batch.fetchSingle(l)
+		}
+		// select picks at random when the batch is also done, e.g. dispatched because every caller cancelled
+		if err := ctx.Err(); err != nil {
+			var zero V
+			return zero, false, err
+		}
+		var timeout <-chan struct{}
+		if l.loadTimeout > 0 {
+			select {
+			case <-batch.done:
+			default:
+				expired := make(chan struct{})
+				t := l.clock.AfterFunc(l.loadTimeout, func() { close(expired) })
+				defer t.Stop()
+				timeout = expired
+			}
+		}
+		select {
+		case <-batch.done:
+		case <-ctx.Done():
+			var zero V
+			return zero, false, ctx.Err()
+		case <-timeout:
+			var zero V
+			return zero, false, ErrLoadTimeout
+		}
+		if l.onLoadWait != nil {
+			l.onLoadWait(key, l.clock.Now().Sub(created))
+		}
+
+		data, found, err := batch.result(pos)
+		if found {
+			// every caller waiting on the batch gets the same value
+			data = l.copyValue(data)
+		}
+
+		return data, found, err
+	}
+}
+
+// unsafeNeedsRefresh reports whether the cached value at key expires within refreshAhead
+// and isn't being fetched yet. l.mu may be held only for reading.
+func (l *Loader[K, V]) unsafeNeedsRefresh(key K) bool {
+	if l.refreshAhead <= 0 || l.refreshing[key] {
+		return false
+	}
+	if _, ok := l.inflight[key]; ok {
+		return false
+	}
+	exp, tracked := l.expires[key]
+	return tracked && exp.Sub(l.clock.Now()) <= l.refreshAhead
+}
+
+// unsafeRefresh fetches key again in the background, replacing the cached value with the
+// fetched one once its batch is done, see unsafeCacheResult. added is called like in loadThunk.
+// l.mu must be held.
+func (l *Loader[K, V]) unsafeRefresh(key K, added func(b *loaderBatch[K, V], key K)) {
+	if l.refreshing == nil {
+		l.refreshing = map[K]bool{}
+	}
+	l.refreshing[key] = true
+	batch := l.unsafePendingBatch(key)
+	if added != nil {
+		added(batch, key)
+	}
+	batch.keyIndex(l, key)
+	batch.watch(l, context.Background())
+}
+
+// cacheResults caches the results of a fetched batch in a single pass holding the lock,
+// so the thunks waiting on it don't each have to take the lock
+func (b *loaderBatch[K, V]) cacheResults(l *Loader[K, V]) {
+	if l.disableCache {
+		return
+	}
+	l.mu.Lock()
+	if b.generation != l.generation {
+		// the loader was reset while the batch was fetched
+		l.unlock()
+		return
+	}
+	// every caller gave up on the batch, so its errors may only be about that
+	abandoned := b.ctx.Err() != nil
+	for i, key := range b.keys {
+		data, found, err := b.result(i)
+		if !abandoned || err == nil {
+			l.unsafeCacheResult(key, data, found, err)
+		}
+		delete(l.refreshing, key)
+	}
+	l.unlock()
+}
+
+// unsafeCacheResult caches the result of fetching key: its value if it was found, otherwise
+// the negative result or error if they are cached.
+// A value primed while key was being fetched is newer than the fetched one, so it is kept.
+// A key being refreshed keeps its value until a new one is found, unless it is primed meanwhile.
+// l.mu must be held.
+func (l *Loader[K, V]) unsafeCacheResult(key K, data V, found bool, err error) {
+	if l.refreshing[key] {
+		// priming the key stops refreshing it, see unsafeSetTTL
+		if found {
+			l.unsafeSet(key, data)
+		}
+		return
+	}
+	if found {
+		if _, primed := l.unsafeGet(key); !primed {
+			l.unsafeSet(key, data)
+		}
+	} else if l.negativeTTL > 0 && (err == nil || l.isNotFound != nil && l.isNotFound(err)) {
+		l.unsafeSetError(key, err)
+		l.unsafeSetErrorExpiry(key, l.negativeTTL)
+	} else if err != nil && l.cacheErrors {
+		l.unsafeSetError(key, err)
+	}
+}
+
+// LoadAll fetches many keys at once. When there are more keys than MaxBatch, the ones that aren't
+// cached are split, in order, into batches of their own of up to MaxBatch keys which are sent right
+// away, so loading n uncached keys fetches exactly ceil(n/MaxBatch) times. Otherwise the keys join
+// the batch collecting keys like any other load.
+func (l *Loader[K, V]) LoadAll(keys []K) ([]V, []error) {
+	return l.LoadAllThunk(keys)()
+}
+
+// LoadAllAggregate is like LoadAll but returns a single *BatchError aggregating the keys that
+// failed, or nil if none did. The values of failed keys are zero values.
+func (l *Loader[K, V]) LoadAllAggregate(keys []K) ([]V, error) {
+	vs, errs := l.LoadAll(keys)
+	if err := NewBatchError(keys, errs); err != nil {
+		return vs, err
+	}
+	return vs, nil
+}
+
+// LoadAllOrError is like LoadAll but returns a *KeyError for the first key that failed, in the
+// order of keys, instead of an error per key. It stops waiting as soon as a key fails, so no
+// values are returned then. Every key is still loaded, and its result cached, as usual.
+func (l *Loader[K, V]) LoadAllOrError(keys []K) ([]V, error) {
+	return l.LoadAllG(context.Background(), keys)
+}
+
+// LoadAllG is like LoadAllOrError but takes a context, returning ctx.Err() as soon as ctx is done,
+// so it fits in an errgroup.Group:
+//
+//	g.Go(func() error {
+//		users, err = loader.LoadAllG(ctx, ids)
+//		return err
+//	})
+func (l *Loader[K, V]) LoadAllG(ctx context.Context, keys []K) ([]V, error) {
+	thunks := make(map[K]func() (V, error), len(keys))
+	for _, key := range keys {
+		if _, ok := thunks[key]; !ok {
+			thunks[key] = l.LoadThunkContext(ctx, key)
+		}
+	}
+	vs := make([]V, len(keys))
+	for i, key := range keys {
+		v, err := thunks[key]()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if err != nil {
+			return nil, &KeyError[K]{Key: key, Err: err}
+		}
+		vs[i] = v
+	}
+	return vs, nil
+}
+
+// LoadAllContext is like LoadAll but takes a context, see LoadThunkContext.
+func (l *Loader[K, V]) LoadAllContext(ctx context.Context, keys []K) ([]V, []error) {
+	return l.LoadAllThunkContext(ctx, keys)()
+}
+
+// LoadAllThunk returns a function that when called will block waiting for a Vs.
+// This method should be used if you want one goroutine to make requests to many
+// different data loaders without blocking until the thunk is called.
+func (l *Loader[K, V]) LoadAllThunk(keys []K) func() ([]V, []error) {
+	return l.LoadAllThunkContext(context.Background(), keys)
+}
+
+// LoadAllThunkContext is like LoadAllThunk but takes a context, see LoadThunkContext.
+// Duplicate keys are loaded once, and their result is copied to every position.
+// Keys are split into batches like LoadAll does.
+func (l *Loader[K, V]) LoadAllThunkContext(ctx context.Context, keys []K) func() ([]V, []error) {
+	// index of the unique key of each key
+	index := make([]int, len(keys))
+	seen := make(map[K]int, len(keys))
+	var unique []K
+	for i, key := range keys {
+		j, ok := seen[key]
+		if !ok {
+			j = len(unique)
+			seen[key] = j
+			unique = append(unique, key)
+		}
+		index[i] = j
+	}
+	l.mu.RLock()
+	maxBatch := l.maxBatch
+	l.mu.RUnlock()
+	var results []func() (V, error)
+	if maxBatch > 0 && len(unique) > maxBatch {
+		results = l.loadChunks(ctx, unique)
+	} else {
+		for _, key := range unique {
+			results = append(results, l.LoadThunkContext(ctx, key))
+		}
+	}
+	return func() ([]V, []error) {
+		uniqueVs := make([]V, len(results))
+		uniqueErrors := make([]error, len(results))
+		for i, thunk := range results {
+			uniqueVs[i], uniqueErrors[i] = thunk()
+		}
+		vs := make([]V, len(keys))
+		errors := make([]error, len(keys))
+		for i, j := range index {
+			vs[i], errors[i] = uniqueVs[j], uniqueErrors[j]
+		}
+		return vs, errors
+	}
+}
+
+// Source is where the result of a load came from, see LoadAllWithSource
+type Source int
+
+const (
+	// FromCache is a value or error served from the cache
+	FromCache Source = iota
+
+	// FromFetch is a result that waited for a batch, including one that was already being fetched
+	FromFetch
+)
+
+func (s Source) String() string {
+	switch s {
+	case FromCache:
+		return "cache"
+	case FromFetch:
+		return "fetch"
+	}
+	return "unknown"
+}
+
+// LoadAllWithSource is like LoadAll but also returns where the result of every key came from,
+// as decided when the key is loaded. The keys join the batch collecting keys like LoadThunk does,
+// without being split by MaxBatch, and a duplicate key after an uncached one is FromFetch too.
+func (l *Loader[K, V]) LoadAllWithSource(keys []K) ([]V, []error, []Source) {
+	thunks := make([]func() (V, bool, error), len(keys))
+	sources := make([]Source, len(keys))
+	for i, key := range keys {
+		var cached bool
+		thunks[i], cached = l.loadThunkCached(context.Background(), key, nil)
+		if !cached {
+			sources[i] = FromFetch
+		}
+	}
+	vs := make([]V, len(keys))
+	errs := make([]error, len(keys))
+	for i, thunk := range thunks {
+		vs[i], _, errs[i] = thunk()
+	}
+	return vs, errs, sources
+}
+
+// LoadThunksAll is like LoadAllThunk but returns a thunk for every key, so the keys are
+// collected into batches together but can be resolved independently, in any order.
+// Duplicate keys share a thunk.
+func (l *Loader[K, V]) LoadThunksAll(keys []K) []func() (V, error) {
+	seen := make(map[K]func() (V, error), len(keys))
+	thunks := make([]func() (V, error), len(keys))
+	for i, key := range keys {
+		thunk, ok := seen[key]
+		if !ok {
+			thunk = l.LoadThunk(key)
+			seen[key] = thunk
+		}
+		thunks[i] = thunk
+	}
+	return thunks
+}
+
+// LoadMap is like LoadAll but returns the results by key. A key is either in the
+// values map or, if it failed, in the errors map. Duplicate keys are loaded once.
+func (l *Loader[K, V]) LoadMap(keys []K) (map[K]V, map[K]error) {
+	return l.LoadMapThunk(keys)()
+}
+
+// LoadMapThunk returns a function that when called will block waiting for the results of keys,
+// see LoadMap.
+func (l *Loader[K, V]) LoadMapThunk(keys []K) func() (map[K]V, map[K]error) {
+	thunks := make(map[K]func() (V, error), len(keys))
+	for _, key := range keys {
+		if _, ok := thunks[key]; !ok {
+			thunks[key] = l.LoadThunk(key)
+		}
+	}
+	return func() (map[K]V, map[K]error) {
+		values := make(map[K]V, len(thunks))
+		errors := map[K]error{}
+		for key, thunk := range thunks {
+			v, err := thunk()
+			if err != nil {
+				errors[key] = err
+				continue
+			}
+			values[key] = v
+		}
+		return values, errors
+	}
+}
+
+// Warm loads keys in the background so they are cached by the time they are needed.
+// It returns right away, and errors are ignored.
+func (l *Loader[K, V]) Warm(keys []K) {
+	thunks := make([]func() (V, error), len(keys))
+	for i, key := range keys {
+		thunks[i] = l.LoadThunk(key)
+	}
+	go func() {
+		for _, thunk := range thunks {
+			_, _ = thunk()
+		}
+	}()
+}
+
+// Prime the cache with the provided key and value. If the key already exists, no change is made
+// and false is returned.
+// (To forcefully prime the cache, use PrimeForce.)
+// A value primed while the key is being fetched is kept over the fetched one, though the
+// thunks waiting on the fetch still resolve to the fetched value.
+// When DisableCache is set, nothing is cached and false is returned.
+func (l *Loader[K, V]) Prime(key K, value V) bool {
+	if l.disableCache {
+		return false
+	}
+	l.mu.Lock()
+	key = l.unsafeIntern(key)
+	var found bool
+	if _, found = l.unsafeGet(key); !found {
+		l.unsafeSet(key, value)
+		l.unsafeRelease(key)
+	}
+	l.unlock()
+	return !found
+}
+
+// computeCall is a call of LoadOrStore computing the value of a key, shared with concurrent calls for the key
+type computeCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// LoadOrStore returns the value cached at key, or else calls compute and caches the value it returns
+// unless it fails. Concurrent calls for the same key wait for the first one's compute instead of calling
+// their own. compute doesn't go through fetch or batches, and a panic in it is returned as a *PanicError.
+func (l *Loader[K, V]) LoadOrStore(key K, compute func() (V, error)) (V, error) {
+	l.mu.Lock()
+	key = l.unsafeIntern(key)
+	if value, ok := l.unsafeGet(key); ok && !l.disableCache {
+		l.unlock()
+		return l.copyValue(value), nil
+	}
+	if call, ok := l.computing[key]; ok {
+		l.unlock()
+		<-call.done
+		if call.err != nil {
+			return call.value, call.err
+		}
+		return l.copyValue(call.value), nil
+	}
+	call := &computeCall[V]{done: make(chan struct{})}
+	if l.computing == nil {
+		l.computing = map[K]*computeCall[V]{}
+	}
+	l.computing[key] = call
+	generation := l.generation
+	l.unlock()
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				call.err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
+		call.value, call.err = compute()
+	}()
+
+	l.mu.Lock()
+	// after a Reset, the key may be computed by a newer call, or not at all
+	if generation == l.generation {
+		delete(l.computing, key)
+		if call.err == nil && !l.disableCache {
+			l.unsafeSet(key, call.value)
+		}
+		l.unsafeRelease(key)
+	}
+	l.unlock()
+	close(call.done)
+	return call.value, call.err
+}
+
+// PrimeMap primes the cache with every entry of entries under a single lock, like Prime does,
+// and returns the number of entries primed. Keys that are already cached are left unchanged.
+func (l *Loader[K, V]) PrimeMap(entries map[K]V) int {
+	if l.disableCache {
+		return 0
+	}
+	l.mu.Lock()
+	primed := 0
+	for key, value := range entries {
+		key = l.unsafeIntern(key)
+		if _, found := l.unsafeGet(key); !found {
+			l.unsafeSet(key, value)
+			l.unsafeRelease(key)
+			primed++
+		}
+	}
+	l.unlock()
+	return primed
+}
+
+// PrimeForce primes the cache with the provided key and value, overwriting any existing value.
+// It does nothing when DisableCache is set.
+func (l *Loader[K, V]) PrimeForce(key K, value V) {
+	if l.disableCache {
+		return
+	}
+	l.mu.Lock()
+	key = l.unsafeIntern(key)
+	l.unsafeSet(key, value)
+	// a full cache may not take it
+	l.unsafeRelease(key)
+	l.unlock()
+}
+
+// PrimeTTL is like PrimeForce but the value expires after ttl instead of TTL, 0 = never.
+func (l *Loader[K, V]) PrimeTTL(key K, value V, ttl time.Duration) {
+	if l.disableCache {
+		return
+	}
+	l.mu.Lock()
+	key = l.unsafeIntern(key)
+	l.unsafeSetTTL(key, value, ttl)
+	l.unsafeRelease(key)
+	l.unlock()
+}
+
+// StagePrime stages value to be primed at key once commit is called, e.g. when the transaction it was
+// read in commits, and discards it when rollback is called instead. Until then the value is not cached,
+// so loads and Snapshot don't see it. commit overwrites any existing value like PrimeForce. Only the
+// first of commit and rollback to be called has an effect.
+func (l *Loader[K, V]) StagePrime(key K, value V) (commit func(), rollback func()) {
+	var once sync.Once
+	commit = func() {
+		once.Do(func() { l.PrimeForce(key, value) })
+	}
+	rollback = func() {
+		once.Do(func() {})
+	}
+	return commit, rollback
+}
+
+// Clear the value or error at key from the cache, if it exists, and the values related to it by IndexFunc
+func (l *Loader[K, V]) Clear(key K) {
+	l.mu.Lock()
+	l.unsafeClearRelated(l.unsafeFindKey(key))
+	l.unlock()
+}
+
+// ClearMany clears the values and errors at keys from the cache, taking the lock only once.
+// Keys that aren't cached are skipped.
+func (l *Loader[K, V]) ClearMany(keys []K) {
+	l.mu.Lock()
+	for _, key := range keys {
+		l.unsafeClearRelated(l.unsafeFindKey(key))
+	}
+	l.unlock()
+}
+
+// ClearError clears the cached error at key, if it exists, so the key will be fetched again.
+// A cached value is left untouched.
+func (l *Loader[K, V]) ClearError(key K) {
+	l.mu.Lock()
+	key = l.unsafeFindKey(key)
+	l.unsafeDeleteError(key)
+	l.unsafeRelease(key)
+	l.mu.Unlock()
+}
+
+// ClearWhere removes every value for which pred returns true from the cache,
+// and returns the number of values removed. pred is passed the cached values, not copies, see LoaderConfig.Copy.
+func (l *Loader[K, V]) ClearWhere(pred func(key K, value V) bool) int {
+	l.mu.Lock()
+	defer l.unlock()
+	var keys []K
+	l.cache.Range(func(key K, value V) bool {
+		if pred(key, value) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		l.unsafeDelete(key)
+		l.unsafeRelease(key)
+	}
+	return len(keys)
+}
+
+// ClearAll removes every value and error from the cache.
+// Thunks that are already waiting on a batch are not affected.
+func (l *Loader[K, V]) ClearAll() {
+	l.mu.Lock()
+	l.unsafeClearAll()
+	l.unlock()
+}
+
+// Snapshot returns a copy of the values in the cache, leaving out expired ones
+func (l *Loader[K, V]) Snapshot() map[K]V {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	snapshot := map[K]V{}
+	l.cache.Range(func(key K, value V) bool {
+		if !l.unsafeExpired(key) {
+			snapshot[key] = l.copyValue(value)
+		}
+		return true
+	})
+	return snapshot
+}
+
+// Has reports whether a value for key is in the cache and hasn't expired, without loading it.
+// Cached errors don't count.
+func (l *Loader[K, V]) Has(key K) bool {
+	if l.concurrentGet {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+	} else {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	}
+	key = l.unsafeFindKey(key)
+	_, ok := l.cache.Get(key)
+	return ok && !l.unsafeExpired(key)
+}
+
+// TTLRemaining returns how long until the cached value for key expires. It reports false if
+// key isn't cached, or its value doesn't expire because TTL isn't set or it wasn't stored by this loader.
+func (l *Loader[K, V]) TTLRemaining(key K) (time.Duration, bool) {
+	if l.concurrentGet {
+		l.mu.RLock()
+		defer l.mu.RUnlock()
+	} else {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	}
+	key = l.unsafeFindKey(key)
+	exp, tracked := l.expires[key]
+	if !tracked || l.unsafeExpired(key) {
+		return 0, false
+	}
+	if _, ok := l.cache.Get(key); !ok {
+		return 0, false
+	}
+	return exp.Sub(l.clock.Now()), true
+}
+
+// Keys returns the keys of the values in the cache, leaving out expired ones, in no particular order
+func (l *Loader[K, V]) Keys() []K {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var keys []K
+	l.cache.Range(func(key K, _ V) bool {
+		if !l.unsafeExpired(key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+// PendingCount returns the number of keys in the batch that is still collecting keys
+func (l *Loader[K, V]) PendingCount() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.batch == nil {
+		return 0
+	}
+	return len(l.batch.keys)
+}
+
+// String describes the state of the loader for debugging: how many values are cached, how many keys
+// the batch collecting keys has, how many batches are closed to new keys and being fetched,
+// and the current wait and maxBatch, e.g.
+//
+//	Loader{cached: 2, pending: 3, fetching: 1, wait: 1ms, maxBatch: 100}
+func (l *Loader[K, V]) String() string {
+	l.mu.RLock()
+	cached := 0
+	l.cache.Range(func(K, V) bool {
+		cached++
+		return true
+	})
+	pending := 0
+	if l.batch != nil {
+		pending = len(l.batch.keys)
+	}
+	fetching, wait, maxBatch := l.closedBatches, l.wait, l.maxBatch
+	l.mu.RUnlock()
+
+	buf := make([]byte, 0, 96)
+	buf = append(buf, "Loader{cached: "...)
+	buf = strconv.AppendInt(buf, int64(cached), 10)
+	buf = append(buf, ", pending: "...)
+	buf = strconv.AppendInt(buf, int64(pending), 10)
+	buf = append(buf, ", fetching: "...)
+	buf = strconv.AppendInt(buf, int64(fetching), 10)
+	buf = append(buf, ", wait: "...)
+	buf = append(buf, wait.String()...)
+	buf = append(buf, ", maxBatch: "...)
+	buf = strconv.AppendInt(buf, int64(maxBatch), 10)
+	buf = append(buf, '}')
+	return string(buf)
+}
+
+// Flush sends the batch that is still collecting keys, if any, right away instead of waiting for
+// its timer, and returns once it is fetched and its thunks resolve.
+func (l *Loader[K, V]) Flush() {
+	l.mu.RLock()
+	b := l.batch
+	l.mu.RUnlock()
+	if b != nil {
+		// timeout doesn't send the batch again if its timer or MaxBatch already did,
+		// in which case it is fetched on another goroutine
+		b.timeout(l)
+		<-b.done
+	}
+}
+
+// ActiveBatches returns the number of batches that have been sent and are being fetched,
+// including ones waiting for MaxConcurrentBatches. See PendingCount for the batch collecting keys.
+func (l *Loader[K, V]) ActiveBatches() int {
+	return int(atomic.LoadInt32(&l.activeBatches))
+}
+
+// CancelPending cancels the batch that is still collecting keys, if any: it is never
+// fetched, and every thunk waiting on it resolves with err.
+func (l *Loader[K, V]) CancelPending(err error) {
+	l.mu.Lock()
+	b := l.batch
+	if b == nil || !b.close(l) {
+		l.mu.Unlock()
+		return
+	}
+	l.mu.Unlock()
+
+	b.error = fillErrors(len(b.keys), err)
+	b.finish(l)
+}
+
+// Reset returns the loader to the state it was created in: the cache is emptied, the batch
+// that is still collecting keys is cancelled with ErrReset, and the stats are zeroed.
+// Batches already being fetched, and LoadOrStore computing values, still resolve their thunks,
+// but what they return is not cached, and later loads of their keys start a new batch instead
+// of waiting for them.
+func (l *Loader[K, V]) Reset() {
+	l.mu.Lock()
+	b := l.batch
+	pending := b != nil && b.close(l)
+	l.unsafeClearAll()
+	l.inflight = nil
+	l.refreshing = nil
+	l.computing = nil
+	if l.interner != nil {
+		l.interner.buckets = nil
+	}
+	l.generation++
+	l.stats.reset()
+	l.unlock()
+
+	if pending {
+		b.error = fillErrors(len(b.keys), ErrReset)
+		b.finish(l)
+	}
+}
+
+// Reconfigure changes how long the loader waits before sending a batch and the maximum number
+// of keys in one batch. The changes take effect from the next batch: the batch that is
+// collecting keys and batches being fetched are not affected.
+func (l *Loader[K, V]) Reconfigure(wait time.Duration, maxBatch int) {
+	l.mu.Lock()
+	l.wait = wait
+	l.maxBatch = maxBatch
+	l.mu.Unlock()
+}
+
+// Wait returns how long the loader waits before sending a batch, see LoaderConfig.Wait and Reconfigure
+func (l *Loader[K, V]) Wait() time.Duration {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.wait
+}
+
+// MaxBatch returns the maximum number of keys in one batch, see LoaderConfig.MaxBatch and Reconfigure
+func (l *Loader[K, V]) MaxBatch() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.maxBatch
+}
+
+// MaxWait returns the longest a batch waits after its first key, see LoaderConfig.MaxWait
+func (l *Loader[K, V]) MaxWait() time.Duration {
+	return l.maxWait
+}
+
+// TTL returns how long a cached value stays fresh, see LoaderConfig.TTL
+func (l *Loader[K, V]) TTL() time.Duration {
+	return l.ttl
+}
+
+// Stop closes the loader: the pending batch is dispatched right away instead of waiting
+// for the timer, and any later load fails with ErrClosed. Thunks already waiting on a batch
+// still resolve. It is safe to call Stop more than once.
+func (l *Loader[K, V]) Stop() {
+	l.mu.Lock()
+	l.closed = true
+	if l.sweeper != nil {
+		l.sweeper.Stop()
+		l.sweeper = nil
+	}
+	if l.batch != nil {
+		l.batch.dispatch(l)
+	}
+	l.unsafeWakePending()
+	l.mu.Unlock()
+}
+
+// sweep removes every expired value and negative result from the cache, then schedules the next sweep
+func (l *Loader[K, V]) sweep() {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return
+	}
+	for key := range l.expires {
+		if l.unsafeExpired(key) {
+			l.unsafeDelete(key)
+			l.unsafeRelease(key)
+		}
+	}
+	for key := range l.errExpires {
+		if l.unsafeErrorExpired(key) {
+			l.unsafeDeleteError(key)
+			l.unsafeRelease(key)
+		}
+	}
+	l.sweeper = l.clock.AfterFunc(l.sweepInterval, l.sweep)
+	l.unlock()
+}
+
+// unsafeClearAll removes every value and error from the cache
+func (l *Loader[K, V]) unsafeClearAll() {
+	if l.onEvict != nil {
+		l.cache.Range(func(key K, value V) bool {
+			l.unsafeEvicted(key, value)
+			return true
+		})
+	}
+	l.cache.Clear()
+	l.index = secondaryIndex[K]{}
+	l.expires = nil
+	l.errs = nil
+	l.errExpires = nil
+	l.unsafeReleaseAll()
+}
+
+// unsafeGet returns the cached value at key. expired values are removed and reported as missing.
+func (l *Loader[K, V]) unsafeGet(key K) (V, bool) {
+	it, ok := l.cache.Get(key)
+	if ok && l.unsafeExpired(key) {
+		l.unsafeDelete(key)
+		var zero V
+		return zero, false
+	}
+	return it, ok
+}
+
+// unsafeExpired reports whether the cached value at key has expired
+func (l *Loader[K, V]) unsafeExpired(key K) bool {
+	exp, tracked := l.expires[key]
+	return tracked && !l.clock.Now().Before(exp)
+}
+
+func (l *Loader[K, V]) unsafeSet(key K, value V) {
+	ttl := l.ttl
+	if l.ttlFor != nil {
+		if d := l.ttlFor(key, value); d > 0 {
+			ttl = d
+		}
+	}
+	l.unsafeSetTTL(key, value, ttl)
+}
+
+// unsafeSetTTL caches value at key for ttl, 0 = forever
+func (l *Loader[K, V]) unsafeSetTTL(key K, value V, ttl time.Duration) {
+	// a newer value than the one being refreshed, if any
+	delete(l.refreshing, key)
+	if !l.unsafeCacheSet(key, l.copyValue(value)) {
+		// the cache refused it, so there is nothing to expire or index either
+		l.index.remove(key)
+		delete(l.expires, key)
+		return
+	}
+	l.unsafeIndex(key, value)
+	if ttl <= 0 {
+		delete(l.expires, key)
+		return
+	}
+	if l.expires == nil {
+		l.expires = map[K]time.Time{}
+	}
+	l.expires[key] = l.clock.Now().Add(ttl)
+}
+
+// unsafeCacheSet stores value at key in the cache, reporting whether it was stored.
+// caches not of this package are trusted to store it.
+func (l *Loader[K, V]) unsafeCacheSet(key K, value V) bool {
+	if c, ok := l.cache.(storingCache[K, V]); ok {
+		return c.set(key, value)
+	}
+	l.cache.Set(key, value)
+	return true
+}
+
+// copyValue returns a copy of value if Copy is set, or value itself
+func (l *Loader[K, V]) copyValue(value V) V {
+	if l.copy == nil {
+		return value
+	}
+	return l.copy(value)
+}
+
+// unsafeGetError returns the cached error or negative result at key.
+// expired ones are removed and reported as missing.
+func (l *Loader[K, V]) unsafeGetError(key K) (bool, error) {
+	err, ok := l.errs[key]
+	if ok && l.unsafeErrorExpired(key) {
+		l.unsafeDeleteError(key)
+		return false, nil
+	}
+	return ok, err
+}
+
+// unsafeErrorExpired reports whether the cached error or negative result at key has expired
+func (l *Loader[K, V]) unsafeErrorExpired(key K) bool {
+	exp, tracked := l.errExpires[key]
+	return tracked && !l.clock.Now().Before(exp)
+}
+
+func (l *Loader[K, V]) unsafeSetErrorExpiry(key K, ttl time.Duration) {
+	if l.errExpires == nil {
+		l.errExpires = map[K]time.Time{}
+	}
+	l.errExpires[key] = l.clock.Now().Add(ttl)
+}
+
+func (l *Loader[K, V]) unsafeDeleteError(key K) {
+	delete(l.errs, key)
+	delete(l.errExpires, key)
+}
+
+func (l *Loader[K, V]) unsafeSetError(key K, err error) {
+	if l.errs == nil {
+		l.errs = map[K]error{}
+	}
+	l.errs[key] = err
+	delete(l.errExpires, key)
+}
+
+func (l *Loader[K, V]) unsafeDelete(key K) {
+	if l.onEvict != nil {
+		if value, ok := l.cache.Get(key); ok {
+			l.unsafeEvicted(key, value)
+		}
+	}
+	l.cache.Delete(key)
+	l.index.remove(key)
+	delete(l.expires, key)
+}
+
+// unsafeEvicted queues key and value for onEvict, which is called by unlock
+func (l *Loader[K, V]) unsafeEvicted(key K, value V) {
+	if l.onEvict != nil {
+		l.evicted = append(l.evicted, cacheEntry[K, V]{key: key, value: value})
+	}
+}
+
+// unlock releases mu, then calls onEvict for the values removed while holding it
+func (l *Loader[K, V]) unlock() {
+	evicted := l.evicted
+	l.evicted = nil
+	l.mu.Unlock()
+	for _, e := range evicted {
+		l.onEvict(e.key, e.value)
+	}
+}
+
+func newLoaderBatch[K comparable, V any](maxBatch int) *loaderBatch[K, V] {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &loaderBatch[K, V]{
+		done:     make(chan struct{}),
+		maxBatch: maxBatch,
+		cancel:   cancel,
+	}
+	b.ctx = context.WithValue(ctx, batchKey{}, b)
+	return b
+}
+
+// unsafePendingBatch returns the batch collecting keys that key should join, creating it if there is none.
+// when key would take the batch over MaxWeight, the batch is sent and a new one is started. l.mu must be held.
+func (l *Loader[K, V]) unsafePendingBatch(key K) *loaderBatch[K, V] {
+	if b := l.batch; b != nil && l.maxWeight > 0 && len(b.keys) > 0 &&
+		b.weight+l.weigh(key) > l.maxWeight && !b.contains(key) {
+		b.dispatch(l)
+	}
+	if l.batch == nil {
+		l.batch = newLoaderBatch[K, V](l.maxBatch)
+		l.batch.events = l.events
+		l.batch.generation = l.generation
+	}
+	return l.batch
+}
+
+// weigh returns the weight of key, see LoaderConfig.BatchWeight
+func (l *Loader[K, V]) weigh(key K) int {
+	if l.batchWeight == nil {
+		return 1
+	}
+	return l.batchWeight(key)
+}
+
+// watch registers ctx as one of the callers waiting on the batch.
+// l.mu must be held.
+func (b *loaderBatch[K, V]) watch(l *Loader[K, V], ctx context.Context) {
+	if ctx.Done() == nil {
+		b.detached = true
+		return
+	}
+	b.unsafeWatchFetching(l, ctx)
+	if deadline, ok := ctx.Deadline(); ok && !b.closing && b.timer != nil && deadline.Before(b.dispatchAt) {
+		// the caller would give up before the batch is sent, so send what we have now
+		b.dispatch(l)
+	}
+	done := ctx.Done()
+	if _, ok := b.watched[done]; ok {
+		return
+	}
+	if b.watched == nil {
+		b.watched = map[<-chan struct{}]struct{}{}
+	}
+	b.watched[done] = struct{}{}
+	b.waiting++
+	go func() {
+		select {
+		case <-done:
+		case <-b.done:
+			return
+		}
+
+		l.mu.Lock()
+		b.waiting--
+		if b.waiting > 0 || b.detached {
+			l.mu.Unlock()
+			return
+		}
+		b.cancel()
+		// nobody is interested in this batch anymore, so don't let new keys join it
+		b.dispatch(l)
+		l.mu.Unlock()
+	}()
+}
+
+// loadCached is the fast path of loadThunk for cache hits, holding only a read lock.
+// it reports false when the key must go through the slow path.
+func (l *Loader[K, V]) loadCached(key K) (func() (V, bool, error), bool) {
+	if !l.concurrentGet || l.disableCache {
+		return nil, false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return nil, false
+	}
+	key = l.unsafeFindKey(key)
+	if l.unsafeNeedsRefresh(key) {
+		return nil, false
+	}
+	if it, ok := l.cache.Get(key); ok && !l.unsafeExpired(key) {
+		it = l.copyValue(it)
+		return func() (V, bool, error) {
+			return it, true, nil
+		}, true
+	}
+	if err, ok := l.errs[key]; ok && !l.unsafeErrorExpired(key) {
+		return func() (V, bool, error) {
+			var zero V
+			return zero, false, err
+		}, true
+	}
+	return nil, false
+}
+
+// result returns the value of the key at pos, whether it was found, and its error.
+// b.done must be closed.
+func (b *loaderBatch[K, V]) result(pos int) (V, bool, error) {
+	var data V
+	if pos < len(b.data) {
+		data = b.data[pos]
+	}
+
+	var err error
+	// its convenient to be able to return a single error for everything,
+	// end has already spread it out unless it is broadcast
+	if len(b.error) == 1 {
+		err = b.error[0]
+	} else if pos < len(b.error) {
+		err = b.error[pos]
+	} else if b.error != nil {
+		// end checks result lengths, but never let a short error slice crash a caller
+		err = fmt.Errorf("%w: %d errors for key %d", ErrResultLength, len(b.error), pos)
+	}
+
+	found := pos < len(b.data)
+	if pos < len(b.found) {
+		found = b.found[pos]
+	}
+
+	return data, found && err == nil, err
+}
+
+// keyIndex will return the location of the key in the batch, if its not found
+// it will add the key to the batch
+func (b *loaderBatch[K, V]) keyIndex(l *Loader[K, V], key K) int {
+	for i, existingKey := range b.keys {
+		if key == existingKey {
+			return i
+		}
+	}
+
+	pos := len(b.keys)
+	b.keys = append(b.keys, key)
+	if pos == 0 {
+		if b.events != nil {
+			b.opened = l.clock.Now()
+			b.send(BatchOpened, b.opened, 0)
+		}
+		b.startTimer(l)
+	} else if b.wait > 0 {
+		b.postpone(l)
+	}
+
+	if b.maxBatch != 0 && pos >= b.maxBatch-1 {
+		b.dispatch(l)
+	}
+	if l.maxWeight > 0 {
+		b.weight += l.weigh(key)
+		if b.weight >= l.maxWeight {
+			b.dispatch(l)
+		}
+	}
+
+	return pos
+}
+
+// contains reports whether key is one of the keys of the batch
+func (b *loaderBatch[K, V]) contains(key K) bool {
+	for _, existingKey := range b.keys {
+		if key == existingKey {
+			return true
+		}
+	}
+	return false
+}
+
+// startTimer schedules the batch to be dispatched after wait. l.mu must be held.
+func (b *loaderBatch[K, V]) startTimer(l *Loader[K, V]) {
+	wait := l.wait
+	if l.jitter > 0 {
+		if l.rand == nil {
+			l.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+		wait += time.Duration(l.rand.Int63n(int64(l.jitter)))
+	}
+	if l.maxWait > 0 && wait > l.maxWait {
+		wait = l.maxWait
+	}
+	if wait == 0 {
+		// no need for a timer. yielding first lets the goroutine adding keys go on, but only on a single P
+		go func() {
+			runtime.Gosched()
+			b.timeout(l)
+		}()
+		return
+	}
+	now := l.clock.Now()
+	b.dispatchAt = now.Add(wait)
+	if l.debounceMode {
+		b.wait = wait
+		if l.maxWait > 0 {
+			b.deadline = now.Add(l.maxWait)
+		}
+		b.timer = b.afterFunc(l, wait)
+		return
+	}
+	if l.scheduler != nil {
+		// don't fetch on the scheduler's goroutine, it is shared with other loaders
+		b.timer = l.scheduler.AfterFunc(wait, func() {
+			l.mu.Lock()
+			b.dispatch(l)
+			l.mu.Unlock()
+		})
+		return
+	}
+	b.timer = l.clock.AfterFunc(wait, func() { b.timeout(l) })
+}
+
+// postpone moves the time the batch is sent to wait from now, but no later than its deadline,
+// see LoaderConfig.DebounceMode. the timer is not touched: when it fires early, debounce starts
+// a new one, so there is at most one timer per wait however many keys join. l.mu must be held.
+func (b *loaderBatch[K, V]) postpone(l *Loader[K, V]) {
+	b.dispatchAt = l.clock.Now().Add(b.wait)
+	if !b.deadline.IsZero() && b.dispatchAt.After(b.deadline) {
+		b.dispatchAt = b.deadline
+	}
+}
+
+// afterFunc schedules debounce after d on the scheduler, or the clock if there is none. l.mu must be held.
+func (b *loaderBatch[K, V]) afterFunc(l *Loader[K, V], d time.Duration) Timer {
+	if l.scheduler != nil {
+		return l.scheduler.AfterFunc(d, func() { b.debounce(l) })
+	}
+	return l.clock.AfterFunc(d, func() { b.debounce(l) })
+}
+
+// debounce sends the batch if it is due, or else waits until it is, see LoaderConfig.DebounceMode
+func (b *loaderBatch[K, V]) debounce(l *Loader[K, V]) {
+	l.mu.Lock()
+	if b.closing {
+		l.mu.Unlock()
+		return
+	}
+	if remaining := b.dispatchAt.Sub(l.clock.Now()); remaining > 0 {
+		b.timer = b.afterFunc(l, remaining)
+		l.mu.Unlock()
+		return
+	}
+	if l.scheduler != nil {
+		// don't fetch on the scheduler's goroutine, it is shared with other loaders
+		b.dispatch(l)
+		l.mu.Unlock()
+		return
+	}
+	closed := b.close(l)
+	l.mu.Unlock()
+
+	if closed {
+		b.end(l)
+	}
+}
+
+func (b *loaderBatch[K, V]) timeout(l *Loader[K, V]) {
+	l.mu.Lock()
+	// if the batch is already closing, we must have hit a batch limit and are already finalizing it
+	closed := b.close(l)
+	l.mu.Unlock()
+
+	if closed {
+		b.end(l)
+	}
+}
+
+// fetchSingle fetches the batch on the calling goroutine if it is still collecting keys
+// and has a single one, see LoaderConfig.SyncSingle
+func (b *loaderBatch[K, V]) fetchSingle(l *Loader[K, V]) {
+	l.mu.Lock()
+	closed := len(b.keys) == 1 && b.close(l)
+	l.mu.Unlock()
+
+	if closed {
+		b.end(l)
+	}
+}
+
+// dispatch closes the batch to new keys and sends it to fetch, unless that already happened.
+// l.mu must be held.
+func (b *loaderBatch[K, V]) dispatch(l *Loader[K, V]) {
+	if b.close(l) {
+		go b.end(l)
+	}
+}
+
+// close closes the batch to new keys, and reports whether it was open. l.mu must be held.
+func (b *loaderBatch[K, V]) close(l *Loader[K, V]) bool {
+	if b.closing {
+		return false
+	}
+	b.closing = true
+	l.closedBatches++
+	if l.batch == b {
+		l.batch = nil
+	}
+	if l.inflight == nil {
+		l.inflight = map[K]inflightKey[K, V]{}
+	}
+	for i, key := range b.keys {
+		l.inflight[key] = inflightKey[K, V]{batch: b, pos: i}
+	}
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	return true
+}
 
 func (b *loaderBatch[K, V]) end(l *Loader[K, V]) {
-	b.data, b.error = l.fetch(b.keys)
+	atomic.AddInt32(&l.activeBatches, 1)
+	defer atomic.AddInt32(&l.activeBatches, -1)
+	atomic.AddInt64(&l.stats.batches, 1)
+	atomic.AddInt64(&l.stats.keysFetched, int64(len(b.keys)))
+	if l.onBatchSize != nil {
+		l.onBatchSize(len(b.keys))
+	}
+	start := l.clock.Now()
+	b.send(BatchDispatched, start, start.Sub(b.opened))
+	keys := b.keys
+	var order []int
+	if l.keyLess != nil {
+		keys, order = sortKeys(b.keys, l.keyLess)
+	}
+	ctx := b.ctx
+	if l.detectDeadlocks {
+		l.mu.Lock()
+		ctx = b.unsafeFetchContext()
+		l.mu.Unlock()
+	}
+	b.data, b.found, b.error = l.retryFetch(ctx, keys)
+	if order != nil {
+		b.data, b.found, b.error = unsort(order, b.data), unsort(order, b.found), unsort(order, b.error)
+	}
+	if l.afterFetch != nil {
+		b.data, b.error = l.afterFetch(b.keys, b.data, b.error)
+		if l.requireResults {
+			b.data, b.error = l.fillMissing(b.keys, b.data, b.error)
+		}
+		if err := checkResultLength(len(b.keys), b.data, b.found, b.error); err != nil {
+			b.data, b.found, b.error = nil, nil, fillErrors(len(b.keys), err)
+		}
+	}
+	if l.singleError == SingleErrorFirstKey && len(b.error) == 1 && len(b.keys) > 1 {
+		errs := make([]error, len(b.keys))
+		errs[0] = b.error[0]
+		b.error = errs
+	}
+	now := l.clock.Now()
+	duration := now.Sub(start)
+	b.send(BatchCompleted, now, duration)
+	if l.onBatch != nil {
+		l.onBatch(b.keys, duration, b.error)
+	}
+	l.observer.BatchDispatched(b.keys, duration, b.error)
+	if l.onResult != nil {
+		for i, key := range b.keys {
+			value, _, err := b.result(i)
+			l.onResult(key, value, err)
+		}
+	}
+	if l.onSlowFetch != nil && l.slowFetchThreshold > 0 && duration > l.slowFetchThreshold {
+		l.onSlowFetch(b.keys, duration)
+	}
+	b.cacheResults(l)
+	b.finish(l)
+}
+
+// finish resolves the thunks waiting on the batch, which must have its results set
+func (b *loaderBatch[K, V]) finish(l *Loader[K, V]) {
+	b.cancel()
+
+	// forget the keys before waking the thunks, so whoever they wake sees the loader without them
+	l.mu.Lock()
+	for _, key := range b.keys {
+		if l.inflight[key].batch == b {
+			delete(l.inflight, key)
+		}
+		l.unsafeRelease(key)
+	}
+	l.closedBatches--
+	l.unsafeWakePending()
+	l.mu.Unlock()
+
 	close(b.done)
 }
+
+// retryFetch calls checkedFetch, retrying up to maxRetries times while the batch fails
+func (l *Loader[K, V]) retryFetch(ctx context.Context, keys []K) ([]V, []bool, []error) {
+	data, found, errs := l.checkedFetch(ctx, keys)
+	for retry := 0; retry < l.maxRetries && l.retriable(errs); retry++ {
+		backoff := make(chan struct{})
+		t := l.clock.AfterFunc(l.retryBackoff, func() { close(backoff) })
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return data, found, errs
+		case <-backoff:
+		}
+		if l.retryFailedKeys && len(errs) > 1 {
+			data, found, errs = l.retryFailed(ctx, keys, data, found, errs)
+			continue
+		}
+		data, found, errs = l.checkedFetch(ctx, keys)
+	}
+	return data, found, errs
+}
+
+// retryFailed fetches again only the keys whose errors should be retried, and merges the results
+// into data, found and errs, which must have one error per key
+func (l *Loader[K, V]) retryFailed(ctx context.Context, keys []K, data []V, found []bool, errs []error) ([]V, []bool, []error) {
+	var failed []int
+	var failedKeys []K
+	for i, err := range errs {
+		if err != nil && (l.shouldRetry == nil || l.shouldRetry(err)) {
+			failed = append(failed, i)
+			failedKeys = append(failedKeys, keys[i])
+		}
+	}
+	retryData, retryFound, retryErrs := l.checkedFetch(ctx, failedKeys)
+
+	merged := make([]V, len(keys))
+	copy(merged, data)
+	mergedFound := make([]bool, len(keys))
+	for i := range mergedFound {
+		mergedFound[i] = i < len(data)
+		// checkResultLength lets empty flags through as no flags
+		if len(found) == len(keys) {
+			mergedFound[i] = found[i]
+		}
+	}
+	mergedErrs := make([]error, len(keys))
+	copy(mergedErrs, errs)
+	for j, i := range failed {
+		var value V
+		if j < len(retryData) {
+			value = retryData[j]
+		}
+		merged[i] = value
+		mergedFound[i] = j < len(retryData)
+		if len(retryFound) == len(failedKeys) {
+			mergedFound[i] = retryFound[j]
+		}
+		switch len(retryErrs) {
+		case 0:
+			mergedErrs[i] = nil
+		case 1:
+			mergedErrs[i] = retryErrs[0]
+		default:
+			mergedErrs[i] = retryErrs[j]
+		}
+	}
+	return merged, mergedFound, mergedErrs
+}
+
+// retriable reports whether any of errs should be retried
+func (l *Loader[K, V]) retriable(errs []error) bool {
+	for _, err := range errs {
+		if err != nil && (l.shouldRetry == nil || l.shouldRetry(err)) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkedFetch calls timedFetch, turning results of the wrong length into an error for every key
+func (l *Loader[K, V]) checkedFetch(ctx context.Context, keys []K) ([]V, []bool, []error) {
+	data, found, errs := l.timedFetch(ctx, keys)
+	if l.requireResults {
+		data, errs = l.fillMissing(keys, data, errs)
+	}
+	if err := checkResultLength(len(keys), data, found, errs); err != nil {
+		return nil, nil, fillErrors(len(keys), err)
+	}
+	return data, found, errs
+}
+
+// fetchResult is what fetch returned, passed from the goroutine timedFetch runs it on
+type fetchResult[V any] struct {
+	data  []V
+	found []bool
+	errs  []error
+}
+
+// timedFetch calls safeFetch, giving up with ErrFetchTimeout for every key after fetchTimeout.
+// fetch keeps running in the background then, and its results are dropped when it returns.
+// every fetch holds a slot of MaxConcurrentBatches until it returns, even in the background.
+func (l *Loader[K, V]) timedFetch(ctx context.Context, keys []K) ([]V, []bool, []error) {
+	if l.fetching != nil {
+		l.fetching <- struct{}{}
+	}
+	if l.fetchTimeout <= 0 {
+		if l.fetching != nil {
+			defer func() { <-l.fetching }()
+		}
+		return l.safeFetch(ctx, keys)
+	}
+	parent := ctx
+	ctx, cancel := context.WithTimeout(ctx, l.fetchTimeout)
+	defer cancel()
+
+	// buffered so the goroutine can exit even if nobody is waiting anymore
+	done := make(chan fetchResult[V], 1)
+	go func() {
+		if l.fetching != nil {
+			defer func() { <-l.fetching }()
+		}
+		data, found, errs := l.safeFetch(ctx, keys)
+		done <- fetchResult[V]{data: data, found: found, errs: errs}
+	}()
+	select {
+	case r := <-done:
+		return r.data, r.found, r.errs
+	case <-ctx.Done():
+		if err := parent.Err(); err != nil {
+			// every caller gave up on the batch, which is no timeout
+			return nil, nil, fillErrors(len(keys), err)
+		}
+		return nil, nil, fillErrors(len(keys), ErrFetchTimeout)
+	}
+}
+
+// safeFetch calls fetch, turning a panic into an error for every key
+func (l *Loader[K, V]) safeFetch(ctx context.Context, keys []K) (data []V, found []bool, errs []error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		if l.onPanic != nil {
+			l.onPanic(r)
+		}
+		l.observer.FetchPanic(r)
+		data, found, errs = nil, nil, fillErrors(len(keys), &PanicError{Value: r, Stack: debug.Stack()})
+	}()
+	return l.fetch(ctx, keys)
+}
+
+// checkResultLength reports an error if data, found or errs can't be matched up with n keys
+func checkResultLength[V any](n int, data []V, found []bool, errs []error) error {
+	if len(data) != 0 && len(data) != n {
+		return fmt.Errorf("%w: %d values for %d keys", ErrResultLength, len(data), n)
+	}
+	if len(found) != 0 && len(found) != n {
+		return fmt.Errorf("%w: %d found flags for %d keys", ErrResultLength, len(found), n)
+	}
+	if len(errs) > 1 && len(errs) != n {
+		return fmt.Errorf("%w: %d errors for %d keys", ErrResultLength, len(errs), n)
+	}
+	return nil
+}
+
+// lineUp matches up the results of FetchMap with keys. keys with neither a value nor an error
+// are not found, and fail with missing if it is set.
+func lineUp[K comparable, V any](keys []K, values map[K]V, errs map[K]error, missing error) ([]V, []bool, []error) {
+	data := make([]V, len(keys))
+	found := make([]bool, len(keys))
+	var errors []error
+	for i, key := range keys {
+		err := errs[key]
+		if err == nil {
+			if data[i], found[i] = values[key]; found[i] {
+				continue
+			}
+			err = missing
+		}
+		if err != nil {
+			if errors == nil {
+				errors = make([]error, len(keys))
+			}
+			errors[i] = err
+		}
+	}
+	return data, found, errors
+}
+
+// sortKeys returns keys sorted by less, and the position in keys of every sorted key
+func sortKeys[K any](keys []K, less func(a, b K) bool) ([]K, []int) {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return less(keys[order[i]], keys[order[j]]) })
+	sorted := make([]K, len(keys))
+	for i, j := range order {
+		sorted[i] = keys[j]
+	}
+	return sorted, order
+}
+
+// unsort puts results matched up with keys sorted by sortKeys back in the order of the keys.
+// results not matched up with every key, like a single error, are returned as is.
+func unsort[T any](order []int, results []T) []T {
+	if len(results) != len(order) {
+		return results
+	}
+	unsorted := make([]T, len(results))
+	for i, j := range order {
+		unsorted[j] = results[i]
+	}
+	return unsorted
+}
+
+// fillMissing fails with ErrNoResult the keys past the end of data without an error in errs,
+// padding data to one value per key, see LoaderConfig.RequireResults
+func (l *Loader[K, V]) fillMissing(keys []K, data []V, errs []error) ([]V, []error) {
+	if len(data) >= len(keys) {
+		return data, errs
+	}
+	if len(errs) == 1 && errs[0] != nil && (l.singleError == SingleErrorBroadcast || len(keys) == 1) {
+		// a broadcast error already fails every key
+		return data, errs
+	}
+	if len(errs) > 1 && len(errs) != len(keys) {
+		// checkResultLength fails every key
+		return data, errs
+	}
+	padded := make([]V, len(keys))
+	copy(padded, data)
+	filled := make([]error, len(keys))
+	copy(filled, errs)
+	for i := len(data); i < len(keys); i++ {
+		if filled[i] == nil {
+			filled[i] = fmt.Errorf("%w for key %v", ErrNoResult, keys[i])
+		}
+	}
+	return padded, filled
+}
+
+// fillErrors returns a slice of n errors all set to err
+func fillErrors(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
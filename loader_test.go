@@ -1,8 +1,14 @@
 package dataloaden_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -127,6 +133,32 @@ func TestLoader_Prime(t *testing.T) {
 	}
 }
 
+func TestLoader_PrimeMap(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 100)
+
+	if got := loader.PrimeMap(map[int]int{1: 1000, 2: 2000, 3: 3000}); got != 2 {
+		t.Errorf("PrimeMap() got = %v, want %v", got, 2)
+	}
+
+	got, _ := loader.LoadAll([]int{1, 2, 3})
+	if want := []int{100, 2000, 3000}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("fetch called %v times, want %v", got, 0)
+	}
+}
+
 func TestLoader_Clear(t *testing.T) {
 	fetch := func(keys []int) ([]int, []error) {
 		ret := make([]int, len(keys))
@@ -156,3 +188,2389 @@ func TestLoader_Clear(t *testing.T) {
 		t.Errorf("want %v, got %v", want, got)
 	}
 }
+
+func TestLoader_ClearMany(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	var evicted []int
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+		OnEvict: func(key int, value int) {
+			evicted = append(evicted, key)
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+	for i := 0; i < 5; i++ {
+		loader.Prime(i, i*100)
+	}
+
+	loader.ClearMany([]int{1, 3, 7})
+
+	got := loader.Keys()
+	sort.Ints(got)
+	if want := []int{0, 2, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() got = %v, want %v", got, want)
+	}
+	sort.Ints(evicted)
+	if want := []int{1, 3}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted = %v, want %v", evicted, want)
+	}
+}
+
+func TestLoader_LoadContext(t *testing.T) {
+	fetched := make(chan error, 1)
+	fetch := func(ctx context.Context, keys []int) ([]int, []error) {
+		fetched <- ctx.Err()
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		FetchContext: fetch,
+		Wait:         1 * time.Millisecond,
+	}
+
+	t.Run("non-cancelled", func(t *testing.T) {
+		loader := dataloaden.NewLoader(config)
+		got, err := loader.LoadContext(context.Background(), 1)
+		if err != nil {
+			t.Errorf("LoadContext() error = %v", err)
+		}
+		if want := 10; got != want {
+			t.Errorf("LoadContext() got = %v, want %v", got, want)
+		}
+		if err := <-fetched; err != nil {
+			t.Errorf("fetch context error = %v", err)
+		}
+	})
+
+	t.Run("one-of-many-cancelled", func(t *testing.T) {
+		loader := dataloaden.NewLoader(config)
+		other := loader.LoadThunk(2)
+		ctx, cancel := context.WithCancel(context.Background())
+		thunk := loader.LoadThunkContext(ctx, 1)
+		cancel()
+		if _, err := thunk(); !errors.Is(err, context.Canceled) {
+			t.Errorf("LoadThunkContext() error = %v, want %v", err, context.Canceled)
+		}
+		if _, err := other(); err != nil {
+			t.Errorf("Load() error = %v", err)
+		}
+		if err := <-fetched; err != nil {
+			t.Errorf("fetch context error = %v", err)
+		}
+	})
+
+	t.Run("all-cancelled", func(t *testing.T) {
+		config := config
+		config.Wait = time.Hour
+		loader := dataloaden.NewLoader(config)
+		ctx, cancel := context.WithCancel(context.Background())
+		thunk := loader.LoadThunkContext(ctx, 1)
+		cancel()
+		if _, err := thunk(); !errors.Is(err, context.Canceled) {
+			t.Errorf("LoadThunkContext() error = %v, want %v", err, context.Canceled)
+		}
+		select {
+		case err := <-fetched:
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("fetch context error = %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("batch was not dispatched after all callers cancelled")
+		}
+	})
+
+	t.Run("shared-context", func(t *testing.T) {
+		config := config
+		config.Wait = time.Hour
+		loader := dataloaden.NewLoader(config)
+		ctx, cancel := context.WithCancel(context.Background())
+		keys := make([]int, 1000)
+		for i := range keys {
+			keys[i] = i
+		}
+		before := runtime.NumGoroutine()
+		thunk := loader.LoadAllThunkContext(ctx, keys)
+		// the loads share a context, so they share the goroutine watching it
+		if got := runtime.NumGoroutine() - before; got > 1 {
+			t.Errorf("LoadAllThunkContext() started %v goroutines, want at most %v", got, 1)
+		}
+		cancel()
+		thunk()
+		<-fetched
+	})
+
+	t.Run("deadline-before-wait", func(t *testing.T) {
+		config := config
+		config.Wait = time.Hour
+		loader := dataloaden.NewLoader(config)
+		other := loader.LoadThunk(2)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		got, err := loader.LoadContext(ctx, 1)
+		if err != nil {
+			t.Errorf("LoadContext() error = %v", err)
+		}
+		if want := 10; got != want {
+			t.Errorf("LoadContext() got = %v, want %v", got, want)
+		}
+		if got, _ := other(); got != 20 {
+			t.Errorf("Load() got = %v, want %v", got, 20)
+		}
+		if err := <-fetched; err != nil {
+			t.Errorf("fetch context error = %v", err)
+		}
+	})
+}
+
+func TestLoader_TTL(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		TTL:   20 * time.Millisecond,
+		Clock: clock,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 1000)
+
+	if got, _ := loader.Load(1); got != 1000 {
+		t.Errorf("Load() got = %v, want %v", got, 1000)
+	}
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("fetch called %v times, want %v", got, 0)
+	}
+
+	clock.Advance(30 * time.Millisecond)
+
+	if got, _ := loader.Load(1); got != 10 {
+		t.Errorf("Load() got = %v, want %v", got, 10)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fetch called %v times, want %v", got, 1)
+	}
+	if want, got := false, loader.Prime(1, 1000); want != got {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func TestLoader_PrimeTTL(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		TTL:   20 * time.Millisecond,
+		TTLFor: func(key int, value int) time.Duration {
+			if key == 3 {
+				return 50 * time.Millisecond
+			}
+			return 0
+		},
+		Clock: clock,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 1000)
+	loader.PrimeTTL(2, 2000, 40*time.Millisecond)
+	loader.PrimeTTL(4, 4000, 0)
+	loader.Load(3)
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		want    []int
+	}{
+		{name: "fresh", want: []int{1000, 2000, 30, 4000}},
+		{name: "global ttl expired", advance: 30 * time.Millisecond, want: []int{10, 2000, 30, 4000}},
+		{name: "primed ttl expired", advance: 15 * time.Millisecond, want: []int{10, 20, 30, 4000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			got := make([]int, 4)
+			for i := range got {
+				got[i], _ = loader.Load(i + 1)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Load() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got, _ := loader.TTLRemaining(3); got != 5*time.Millisecond {
+		t.Errorf("TTLRemaining() got = %v, want %v", got, 5*time.Millisecond)
+	}
+}
+
+func TestLoader_TTLRemaining(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		TTL:   20 * time.Millisecond,
+		Clock: clock,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 100)
+
+	tests := []struct {
+		name    string
+		key     int
+		advance time.Duration
+		want    time.Duration
+		wantOK  bool
+	}{
+		{name: "fresh", key: 1, want: 20 * time.Millisecond, wantOK: true},
+		{name: "decreasing", key: 1, advance: 5 * time.Millisecond, want: 15 * time.Millisecond, wantOK: true},
+		{name: "uncached", key: 2, wantOK: false},
+		{name: "expired", key: 1, advance: 15 * time.Millisecond, wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			got, ok := loader.TTLRemaining(tt.key)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("TTLRemaining() got = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+
+	t.Run("no ttl", func(t *testing.T) {
+		loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{Fetch: fetch})
+		loader.Prime(1, 100)
+		if got, ok := loader.TTLRemaining(1); ok {
+			t.Errorf("TTLRemaining() got = %v, %v, want %v, %v", got, ok, 0, false)
+		}
+	})
+}
+
+func TestLoader_RefreshAhead(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	fetch := func(keys []int) ([]int, []error) {
+		n := atomic.AddInt32(&calls, 1)
+		<-release
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = int(n) * 1000
+		}
+		return ret, nil
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:        fetch,
+		TTL:          100 * time.Millisecond,
+		RefreshAhead: 30 * time.Millisecond,
+		Clock:        clock,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 1)
+
+	if got, _ := loader.Load(1); got != 1 {
+		t.Errorf("Load() got = %v, want %v", got, 1)
+	}
+	clock.Advance(80 * time.Millisecond)
+
+	// both are served the stale value, and only the first starts a refresh
+	for i := 0; i < 2; i++ {
+		if got, _ := loader.Load(1); got != 1 {
+			t.Errorf("Load() got = %v, want %v", got, 1)
+		}
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		got, _ := loader.Load(1)
+		if got == 1000 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Load() got = %v after refresh, want %v", got, 1000)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fetch called %v times, want %v", got, 1)
+	}
+	if got, _ := loader.TTLRemaining(1); got != 100*time.Millisecond {
+		t.Errorf("TTLRemaining() got = %v, want %v", got, 100*time.Millisecond)
+	}
+}
+
+func TestLoader_RefreshAheadPrimed(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(keys []int) ([]int, []error) {
+		close(started)
+		<-release
+		return []int{1000}, nil
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:        fetch,
+		TTL:          100 * time.Millisecond,
+		RefreshAhead: 30 * time.Millisecond,
+		Clock:        clock,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 1)
+	clock.Advance(80 * time.Millisecond)
+	loader.Load(1)
+	<-started
+
+	// a value primed while the key is refreshed is newer than the refreshed one
+	loader.PrimeForce(1, 2)
+	close(release)
+	for loader.ActiveBatches() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if got, _ := loader.Load(1); got != 2 {
+		t.Errorf("Load() got = %v, want %v", got, 2)
+	}
+}
+
+func TestLoader_SweepInterval(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	var mu sync.Mutex
+	var evicted []int
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:         fetch,
+		TTL:           20 * time.Millisecond,
+		SweepInterval: 50 * time.Millisecond,
+		Clock:         clock,
+		OnEvict: func(key int, value int) {
+			mu.Lock()
+			evicted = append(evicted, key)
+			mu.Unlock()
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 100)
+	loader.Prime(2, 200)
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		want    []int
+	}{
+		{name: "expired but not swept yet", advance: 30 * time.Millisecond, want: nil},
+		{name: "swept", advance: 20 * time.Millisecond, want: []int{1, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			mu.Lock()
+			defer mu.Unlock()
+			sort.Ints(evicted)
+			if !reflect.DeepEqual(evicted, tt.want) {
+				t.Errorf("evicted = %v, want %v", evicted, tt.want)
+			}
+		})
+	}
+
+	loader.Prime(3, 300)
+	loader.Stop()
+	clock.Advance(200 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []int{1, 2}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("evicted after Stop = %v, want %v", evicted, want)
+	}
+}
+
+func TestLoader_ClearAll(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+	for i := 0; i < 3; i++ {
+		loader.Prime(i, 1000)
+	}
+
+	loader.ClearAll()
+
+	got, _ := loader.LoadAll([]int{0, 1, 2})
+	if want := []int{0, 10, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+}
+
+func TestLoader_PrimeForce(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.Prime(10, 100)
+	loader.PrimeForce(10, 1000)
+
+	if got, _ := loader.Load(10); got != 1000 {
+		t.Errorf("Load() got = %v, want %v", got, 1000)
+	}
+}
+
+func TestLoader_CacheErrors(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, []error{errors.New("some error")}
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:       fetch,
+		Wait:        1 * time.Millisecond,
+		CacheErrors: true,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	for i := 0; i < 3; i++ {
+		if _, err := loader.Load(1); err == nil {
+			t.Errorf("Load() error = %v, wantErr %v", err, true)
+		}
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fetch called %v times, want %v", got, 1)
+	}
+
+	loader.ClearError(1)
+	if _, err := loader.Load(1); err == nil {
+		t.Errorf("Load() error = %v, wantErr %v", err, true)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("fetch called %v times, want %v", got, 2)
+	}
+}
+
+func TestLoader_FetchPanic(t *testing.T) {
+	var recovered any
+	fetch := func(keys []int) ([]int, []error) {
+		panic("boom")
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:   fetch,
+		Wait:    1 * time.Millisecond,
+		OnPanic: func(r any) { recovered = r },
+	}
+	loader := dataloaden.NewLoader(config)
+
+	_, errs := loader.LoadAll([]int{1, 2})
+	for _, err := range errs {
+		var panicErr *dataloaden.PanicError
+		if !errors.As(err, &panicErr) {
+			t.Errorf("LoadAll() error = %v, want *PanicError", err)
+			continue
+		}
+		if panicErr.Value != "boom" {
+			t.Errorf("PanicError.Value = %v, want %v", panicErr.Value, "boom")
+		}
+	}
+	if recovered != "boom" {
+		t.Errorf("OnPanic recovered = %v, want %v", recovered, "boom")
+	}
+}
+
+func TestLoader_ResultLength(t *testing.T) {
+	someErr := errors.New("some error")
+	tests := []struct {
+		name    string
+		data    []int
+		errs    []error
+		want    []int
+		wantErr []error
+	}{
+		{name: "no-errors", data: []int{1, 2, 3}, errs: nil, want: []int{1, 2, 3}, wantErr: []error{nil, nil, nil}},
+		{name: "single-error", data: nil, errs: []error{someErr}, want: []int{0, 0, 0}, wantErr: []error{someErr, someErr, someErr}},
+		{name: "no-results", data: nil, errs: nil, want: []int{0, 0, 0}, wantErr: []error{nil, nil, nil}},
+		{name: "short-data", data: []int{1}, errs: nil, want: []int{0, 0, 0}, wantErr: []error{dataloaden.ErrResultLength, dataloaden.ErrResultLength, dataloaden.ErrResultLength}},
+		{name: "long-data", data: []int{1, 2, 3, 4}, errs: nil, want: []int{0, 0, 0}, wantErr: []error{dataloaden.ErrResultLength, dataloaden.ErrResultLength, dataloaden.ErrResultLength}},
+		{name: "short-errors", data: []int{1, 2, 3}, errs: []error{nil, someErr}, want: []int{0, 0, 0}, wantErr: []error{dataloaden.ErrResultLength, dataloaden.ErrResultLength, dataloaden.ErrResultLength}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := dataloaden.LoaderConfig[int, int]{
+				Fetch: func(keys []int) ([]int, []error) { return tt.data, tt.errs },
+				Wait:  1 * time.Millisecond,
+			}
+			loader := dataloaden.NewLoader(config)
+			got, errs := loader.LoadAll([]int{1, 2, 3})
+			for i := range errs {
+				if !errors.Is(errs[i], tt.wantErr[i]) {
+					t.Errorf("LoadAll() error[%d] = %v, want %v", i, errs[i], tt.wantErr[i])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadAll() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoader_OnBatch(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		time.Sleep(5 * time.Millisecond)
+		return make([]int, len(keys)), nil
+	}
+	var gotKeys []int
+	var gotDuration time.Duration
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+		OnBatch: func(keys []int, duration time.Duration, err []error) {
+			gotKeys = keys
+			gotDuration = duration
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.LoadAll([]int{1, 2, 3})
+
+	if want := 3; len(gotKeys) != want {
+		t.Errorf("OnBatch keys = %v, want %v keys", gotKeys, want)
+	}
+	if want := 5 * time.Millisecond; gotDuration < want {
+		t.Errorf("OnBatch duration = %v, want at least %v", gotDuration, want)
+	}
+}
+
+func TestLoader_OnSlowFetch(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		if keys[0] == 1 {
+			time.Sleep(20 * time.Millisecond)
+		}
+		return make([]int, len(keys)), nil
+	}
+	var mu sync.Mutex
+	var slow [][]int
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:              fetch,
+		Wait:               1 * time.Millisecond,
+		MaxBatch:           1,
+		SlowFetchThreshold: 10 * time.Millisecond,
+		OnSlowFetch: func(keys []int, duration time.Duration) {
+			if want := 20 * time.Millisecond; duration < want {
+				t.Errorf("OnSlowFetch duration = %v, want at least %v", duration, want)
+			}
+			mu.Lock()
+			slow = append(slow, keys)
+			mu.Unlock()
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.LoadAll([]int{1, 2, 3})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := [][]int{{1}}; !reflect.DeepEqual(slow, want) {
+		t.Errorf("OnSlowFetch keys = %v, want %v", slow, want)
+	}
+}
+
+func TestLoader_OnLoadWait(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	var mu sync.Mutex
+	waited := map[int]time.Duration{}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  10 * time.Millisecond,
+		OnLoadWait: func(key int, d time.Duration) {
+			mu.Lock()
+			waited[key] = d
+			mu.Unlock()
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 100)
+
+	loader.LoadAll([]int{1, 2, 3})
+
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := waited[1]; ok {
+		t.Errorf("OnLoadWait called for cached key %v", 1)
+	}
+	for _, key := range []int{2, 3} {
+		if d, want := waited[key], 10*time.Millisecond; d < want || d > time.Second {
+			t.Errorf("OnLoadWait waited = %v for key %v, want at least %v", d, key, want)
+		}
+	}
+}
+
+func TestLoader_MaxConcurrentBatches(t *testing.T) {
+	var running, peak int32
+	fetch := func(keys []int) ([]int, []error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:                fetch,
+		Wait:                 1 * time.Millisecond,
+		MaxBatch:             1,
+		MaxConcurrentBatches: 2,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	_, errs := loader.LoadAll([]int{1, 2, 3, 4, 5, 6})
+	for _, err := range errs {
+		if err != nil {
+			t.Errorf("LoadAll() error = %v", err)
+		}
+	}
+	if got, want := atomic.LoadInt32(&peak), int32(2); got != want {
+		t.Errorf("peak concurrency = %v, want %v", got, want)
+	}
+}
+
+func TestLoader_MaxConcurrentBatchesFetchTimeout(t *testing.T) {
+	var running, peak, calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// outlives FetchTimeout
+			time.Sleep(20 * time.Millisecond)
+		}
+		atomic.AddInt32(&running, -1)
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:                fetch,
+		Wait:                 1 * time.Millisecond,
+		FetchTimeout:         5 * time.Millisecond,
+		MaxRetries:           1,
+		MaxConcurrentBatches: 1,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	// the retry waits for the fetch that timed out to return
+	if _, err := loader.Load(1); err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+	if got, want := atomic.LoadInt32(&peak), int32(1); got != want {
+		t.Errorf("peak concurrency = %v, want %v", got, want)
+	}
+}
+
+func TestLoader_Retry(t *testing.T) {
+	errTransient := errors.New("transient error")
+	errPermanent := errors.New("permanent error")
+	newFetch := func(failures int32, err error) (func(keys []int) ([]int, []error), *int32) {
+		var calls int32
+		return func(keys []int) ([]int, []error) {
+			if atomic.AddInt32(&calls, 1) <= failures {
+				return nil, []error{err}
+			}
+			return make([]int, len(keys)), nil
+		}, &calls
+	}
+
+	tests := []struct {
+		name      string
+		failures  int32
+		err       error
+		wantErr   error
+		wantCalls int32
+	}{
+		{name: "success-after-retry", failures: 2, err: errTransient, wantErr: nil, wantCalls: 3},
+		{name: "exhausted-retries", failures: 5, err: errTransient, wantErr: errTransient, wantCalls: 4},
+		{name: "not-retriable", failures: 5, err: errPermanent, wantErr: errPermanent, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fetch, calls := newFetch(tt.failures, tt.err)
+			config := dataloaden.LoaderConfig[int, int]{
+				Fetch:        fetch,
+				Wait:         1 * time.Millisecond,
+				MaxRetries:   3,
+				RetryBackoff: 1 * time.Millisecond,
+				ShouldRetry:  func(err error) bool { return errors.Is(err, errTransient) },
+			}
+			loader := dataloaden.NewLoader(config)
+			if _, err := loader.Load(1); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Load() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(calls); got != tt.wantCalls {
+				t.Errorf("fetch called %v times, want %v", got, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestLoader_RetryFailedKeys(t *testing.T) {
+	errTransient := errors.New("transient error")
+	var mu sync.Mutex
+	var fetched [][]int
+	fetch := func(keys []int) ([]int, []error) {
+		mu.Lock()
+		first := len(fetched) == 0
+		fetched = append(fetched, keys)
+		mu.Unlock()
+		ret := make([]int, len(keys))
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			if first && key%2 == 1 {
+				errs[i] = errTransient
+				continue
+			}
+			ret[i] = key * 10
+		}
+		return ret, errs
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:           fetch,
+		Wait:            1 * time.Millisecond,
+		MaxRetries:      3,
+		RetryBackoff:    1 * time.Millisecond,
+		RetryFailedKeys: true,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	got, errs := loader.LoadAll([]int{1, 2, 3, 4})
+	if want := []int{10, 20, 30, 40}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if want := []error{nil, nil, nil, nil}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("LoadAll() errs = %v, want %v", errs, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := [][]int{{1, 2, 3, 4}, {1, 3}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_RetryFailedKeysNoFlags(t *testing.T) {
+	var calls int32
+	fetch := func(_ context.Context, keys []int) ([]int, []bool, []error) {
+		first := atomic.AddInt32(&calls, 1) == 1
+		ret := make([]int, len(keys))
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			if first && key == 1 {
+				errs[i] = errors.New("transient error")
+				continue
+			}
+			ret[i] = key * 10
+		}
+		// empty flags are no flags
+		return ret, []bool{}, errs
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		FetchExists:     fetch,
+		Wait:            1 * time.Millisecond,
+		MaxRetries:      1,
+		RetryFailedKeys: true,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	got, errs := loader.LoadAll([]int{1, 2})
+	if want := []int{10, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if want := []error{nil, nil}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("LoadAll() errs = %v, want %v", errs, want)
+	}
+}
+
+func TestLoader_LoadMap(t *testing.T) {
+	var fetched []int
+	fetch := func(keys []int) ([]int, []error) {
+		fetched = append(fetched, keys...)
+		ret := make([]int, len(keys))
+		retErr := make([]error, len(keys))
+		for i := range keys {
+			if keys[i]%2 == 0 {
+				ret[i] = keys[i] * 10
+			} else {
+				retErr[i] = errors.New("some error")
+			}
+		}
+		return ret, retErr
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	got, gotErr := loader.LoadMap([]int{0, 1, 2, 2, 1})
+	if want := map[int]int{0: 0, 2: 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadMap() got = %v, want %v", got, want)
+	}
+	if _, ok := gotErr[1]; !ok || len(gotErr) != 1 {
+		t.Errorf("LoadMap() error = %v, want only key 1", gotErr)
+	}
+	if len(fetched) != 3 {
+		t.Errorf("fetched %v, want 3 keys", fetched)
+	}
+}
+
+func TestLoader_ClearWhere(t *testing.T) {
+	var fetched []int
+	fetch := func(keys []int) ([]int, []error) {
+		fetched = append(fetched, keys...)
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+	for i := 0; i < 4; i++ {
+		loader.Prime(i, i*10)
+	}
+
+	if got, want := loader.ClearWhere(func(key, value int) bool { return value >= 20 }), 2; got != want {
+		t.Errorf("ClearWhere() got = %v, want %v", got, want)
+	}
+
+	got, _ := loader.LoadAll([]int{0, 1, 2, 3})
+	if want := []int{0, 10, 0, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if want := []int{2, 3}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_Stop(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Hour,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	thunk := loader.LoadThunk(1)
+	loader.Stop()
+	loader.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if got, err := thunk(); err != nil || got != 10 {
+			t.Errorf("thunk() got = %v, %v, want %v, %v", got, err, 10, nil)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("pending key was not dispatched by Stop")
+	}
+
+	if _, err := loader.Load(2); !errors.Is(err, dataloaden.ErrClosed) {
+		t.Errorf("Load() error = %v, want %v", err, dataloaden.ErrClosed)
+	}
+}
+
+func BenchmarkLoader_Load(b *testing.B) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	for _, wait := range []time.Duration{0, 10 * time.Microsecond} {
+		b.Run(fmt.Sprintf("wait=%v", wait), func(b *testing.B) {
+			loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+				Fetch: fetch,
+				Wait:  wait,
+			})
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				loader.Load(i)
+			}
+		})
+	}
+}
+
+func TestLoader_SyncSingle(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	fetch := func(keys []int) ([]int, []error) {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		return keys, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:      fetch,
+		Wait:       1 * time.Hour,
+		SyncSingle: true,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	if got, _ := loader.Load(1); got != 1 {
+		t.Errorf("Load() got = %v, want %v", got, 1)
+	}
+	thunk2, thunk3 := loader.LoadThunk(2), loader.LoadThunk(3)
+	go func() {
+		// the batch has two keys, so it waits for Flush
+		time.Sleep(5 * time.Millisecond)
+		loader.Flush()
+	}()
+	thunk2()
+	thunk3()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := [][]int{{1}, {2, 3}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+}
+
+func BenchmarkLoader_SyncSingle(b *testing.B) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	for _, syncSingle := range []bool{false, true} {
+		b.Run(fmt.Sprintf("syncSingle=%v", syncSingle), func(b *testing.B) {
+			loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+				Fetch:      fetch,
+				Wait:       100 * time.Microsecond,
+				SyncSingle: syncSingle,
+			})
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				loader.Load(i)
+			}
+		})
+	}
+}
+
+func TestLoader_StagePrime(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i, key := range keys {
+			ret[i] = key * 10
+		}
+		return ret, nil
+	}
+	tests := []struct {
+		name   string
+		finish func(commit, rollback func())
+		want   int
+	}{
+		{name: "commit", finish: func(commit, rollback func()) { commit(); rollback() }, want: 100},
+		{name: "rollback", finish: func(commit, rollback func()) { rollback(); commit() }, want: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := dataloaden.LoaderConfig[int, int]{
+				Fetch: fetch,
+				Wait:  1 * time.Millisecond,
+			}
+			loader := dataloaden.NewLoader(config)
+
+			commit, rollback := loader.StagePrime(1, 100)
+			if got := loader.Snapshot(); len(got) != 0 {
+				t.Errorf("Snapshot() got = %v, want empty", got)
+			}
+			if loader.Has(1) {
+				t.Errorf("Has() got = %v, want %v", true, false)
+			}
+
+			tt.finish(commit, rollback)
+			if got, _ := loader.Load(1); got != tt.want {
+				t.Errorf("Load() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkLoader_LargeBatch(b *testing.B) {
+	const n, waiters = 10000, 8
+	fetch := func(keys []int) ([]int, []error) {
+		return keys, nil
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+			Fetch: fetch,
+			Wait:  time.Hour,
+		})
+		thunks := make([]func() (int, error), n)
+		for k := range thunks {
+			thunks[k] = loader.LoadThunk(k)
+		}
+		var wg sync.WaitGroup
+		for w := 0; w < waiters; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for k := w; k < n; k += waiters {
+					thunks[k]()
+				}
+			}(w)
+		}
+		b.StartTimer()
+
+		// only measure fetching the batch and resolving its thunks
+		loader.Flush()
+		wg.Wait()
+	}
+}
+
+func TestLoader_LoadAllWithSource(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i, key := range keys {
+			ret[i] = key * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 100)
+	loader.Prime(3, 300)
+
+	got, _, sources := loader.LoadAllWithSource([]int{1, 2, 3, 4, 2})
+	if want := []int{100, 20, 300, 40, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAllWithSource() got = %v, want %v", got, want)
+	}
+	want := []dataloaden.Source{dataloaden.FromCache, dataloaden.FromFetch, dataloaden.FromCache, dataloaden.FromFetch, dataloaden.FromFetch}
+	if !reflect.DeepEqual(sources, want) {
+		t.Errorf("LoadAllWithSource() sources = %v, want %v", sources, want)
+	}
+
+	// fetched keys are cached now
+	if _, _, sources := loader.LoadAllWithSource([]int{2, 4}); !reflect.DeepEqual(sources, []dataloaden.Source{dataloaden.FromCache, dataloaden.FromCache}) {
+		t.Errorf("LoadAllWithSource() sources = %v, want all %v", sources, dataloaden.FromCache)
+	}
+}
+
+func TestLoader_PendingCount(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Hour,
+	}
+	loader := dataloaden.NewLoader(config)
+	defer loader.Stop()
+
+	if got := loader.PendingCount(); got != 0 {
+		t.Errorf("PendingCount() got = %v, want %v", got, 0)
+	}
+	loader.LoadAllThunk([]int{1, 2, 2, 3})
+	if got := loader.PendingCount(); got != 3 {
+		t.Errorf("PendingCount() got = %v, want %v", got, 3)
+	}
+}
+
+func TestLoader_Warm(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.Warm([]int{1, 2})
+	time.Sleep(20 * time.Millisecond)
+
+	got, _ := loader.LoadAll([]int{1, 2})
+	if want := []int{10, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fetch called %v times, want %v", got, 1)
+	}
+}
+
+func TestLoader_LoadExists(t *testing.T) {
+	var fetched []int
+	fetch := func(ctx context.Context, keys []int) ([]int, []bool, []error) {
+		fetched = append(fetched, keys...)
+		ret := make([]int, len(keys))
+		found := make([]bool, len(keys))
+		for i := range keys {
+			// only even keys exist, and their value is zero
+			found[i] = keys[i]%2 == 0
+		}
+		return ret, found, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		FetchExists: fetch,
+		Wait:        1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(-1, 1000)
+
+	tests := []struct {
+		name      string
+		arg       int
+		want      int
+		wantFound bool
+	}{
+		{name: "found-zero", arg: 0, want: 0, wantFound: true},
+		{name: "not-found", arg: 1, want: 0, wantFound: false},
+		{name: "cached", arg: -1, want: 1000, wantFound: true},
+		{name: "found-zero-cached", arg: 0, want: 0, wantFound: true},
+		{name: "not-found-again", arg: 1, want: 0, wantFound: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found, err := loader.LoadExists(tt.arg)
+			if err != nil {
+				t.Errorf("LoadExists() error = %v", err)
+			}
+			if got != tt.want || found != tt.wantFound {
+				t.Errorf("LoadExists() got = %v, %v, want %v, %v", got, found, tt.want, tt.wantFound)
+			}
+		})
+	}
+	if want := []int{0, 1, 1}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_NegativeTTL(t *testing.T) {
+	errNotFound := errors.New("not found")
+	var fetched []int
+	fetch := func(ctx context.Context, keys []int) ([]int, []bool, []error) {
+		fetched = append(fetched, keys...)
+		ret := make([]int, len(keys))
+		found := make([]bool, len(keys))
+		retErr := make([]error, len(keys))
+		for i := range keys {
+			switch keys[i] {
+			case 1:
+				ret[i], found[i] = 10, true
+			case 2:
+				retErr[i] = errNotFound
+			}
+		}
+		return ret, found, retErr
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		FetchExists: fetch,
+		TTL:         100 * time.Millisecond,
+		NegativeTTL: 10 * time.Millisecond,
+		IsNotFound:  func(err error) bool { return errors.Is(err, errNotFound) },
+		Clock:       clock,
+	}
+	loader := dataloaden.NewLoader(config)
+	keys := []int{1, 2, 3}
+
+	loader.LoadAll(keys)
+	loader.LoadAll(keys)
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+
+	fetched = nil
+	clock.Advance(20 * time.Millisecond)
+	_, errs := loader.LoadAll(keys)
+	if !errors.Is(errs[1], errNotFound) {
+		t.Errorf("LoadAll() error = %v, want %v", errs[1], errNotFound)
+	}
+	if want := []int{2, 3}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+
+	fetched = nil
+	clock.Advance(100 * time.Millisecond)
+	loader.LoadAll(keys)
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_Snapshot(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	if got := loader.Snapshot(); got == nil || len(got) != 0 {
+		t.Errorf("Snapshot() got = %#v, want an empty map", got)
+	}
+
+	loader.Prime(1, 10)
+	loader.Prime(2, 20)
+	got := loader.Snapshot()
+	if want := map[int]int{1: 10, 2: 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Snapshot() got = %v, want %v", got, want)
+	}
+
+	got[3] = 30
+	if want := map[int]int{1: 10, 2: 20}; !reflect.DeepEqual(loader.Snapshot(), want) {
+		t.Errorf("Snapshot() got = %v, want %v", loader.Snapshot(), want)
+	}
+}
+
+func TestLoader_LoadAll_Duplicates(t *testing.T) {
+	var fetched []int
+	fetch := func(keys []int) ([]int, []error) {
+		fetched = append(fetched, keys...)
+		ret := make([]int, len(keys))
+		retErr := make([]error, len(keys))
+		for i := range keys {
+			if keys[i]%2 == 0 {
+				ret[i] = keys[i] * 10
+			} else {
+				retErr[i] = errors.New("some error")
+			}
+		}
+		return ret, retErr
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(-1, 1000)
+
+	got, errs := loader.LoadAll([]int{2, -1, 1, 2, -1, 1})
+	if want := []int{20, 1000, 0, 20, 1000, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	gotErr := make([]bool, len(errs))
+	for i := range errs {
+		gotErr[i] = errs[i] != nil
+	}
+	if want := []bool{false, false, true, false, false, true}; !reflect.DeepEqual(gotErr, want) {
+		t.Errorf("LoadAll() error = %v, want %v", errs, want)
+	}
+	if want := []int{2, 1}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+	if got := loader.Stats().CacheHits + loader.Stats().CacheMisses; got != 3 {
+		t.Errorf("loads = %v, want %v", got, 3)
+	}
+}
+
+func BenchmarkLoader_LoadAll_Duplicates(b *testing.B) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	keys := make([]int, 1000)
+	for i := range keys {
+		keys[i] = i % 10
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{Fetch: fetch})
+		loader.LoadAll(keys)
+	}
+}
+
+func TestLoader_CancelPending(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Hour,
+	}
+	loader := dataloaden.NewLoader(config)
+	errAborted := errors.New("aborted")
+
+	thunk := loader.LoadAllThunk([]int{1, 2})
+	loader.CancelPending(errAborted)
+
+	_, errs := thunk()
+	for _, err := range errs {
+		if !errors.Is(err, errAborted) {
+			t.Errorf("LoadAll() error = %v, want %v", err, errAborted)
+		}
+	}
+	if got := loader.PendingCount(); got != 0 {
+		t.Errorf("PendingCount() got = %v, want %v", got, 0)
+	}
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("fetch called %v times, want %v", got, 0)
+	}
+}
+
+func TestLoader_LoadMapThunk(t *testing.T) {
+	var fetched []int
+	fetch := func(keys []int) ([]int, []error) {
+		fetched = append(fetched, keys...)
+		ret := make([]int, len(keys))
+		retErr := make([]error, len(keys))
+		for i := range keys {
+			if keys[i]%2 == 0 {
+				ret[i] = keys[i] * 10
+			} else {
+				retErr[i] = errors.New("some error")
+			}
+		}
+		return ret, retErr
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	thunk := loader.LoadMapThunk([]int{4, 3, 4})
+	other := loader.LoadMapThunk([]int{3, 6})
+
+	got, gotErr := thunk()
+	if want := map[int]int{4: 40}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadMapThunk() got = %v, want %v", got, want)
+	}
+	if _, ok := gotErr[3]; !ok || len(gotErr) != 1 {
+		t.Errorf("LoadMapThunk() error = %v, want only key 3", gotErr)
+	}
+	if got, _ := other(); !reflect.DeepEqual(got, map[int]int{6: 60}) {
+		t.Errorf("LoadMapThunk() got = %v, want %v", got, map[int]int{6: 60})
+	}
+	if want := []int{4, 3, 6}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v in one batch", fetched, want)
+	}
+}
+
+func TestLoader_Keys(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+	for _, key := range []int{3, 1, 2} {
+		loader.Prime(key, key*10)
+	}
+
+	got := loader.Keys()
+	sort.Ints(got)
+	if want := []int{1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Keys() got = %v, want %v", got, want)
+	}
+}
+
+func TestLoader_AfterFetch(t *testing.T) {
+	errEmpty := errors.New("empty record")
+	fetch := func(keys []int) ([]string, []error) {
+		return []string{"a", "", "c"}, nil
+	}
+	config := dataloaden.LoaderConfig[int, string]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+		AfterFetch: func(keys []int, values []string, errs []error) ([]string, []error) {
+			errs = make([]error, len(keys))
+			for i := range values {
+				if values[i] == "" {
+					errs[i] = errEmpty
+				}
+			}
+			return values, errs
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+
+	got, errs := loader.LoadAll([]int{1, 2, 3})
+	if want := []string{"a", "", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if want := []error{nil, errEmpty, nil}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("LoadAll() error = %v, want %v", errs, want)
+	}
+}
+
+func BenchmarkLoader_Load_Parallel(b *testing.B) {
+	const n = 1000
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	configs := []struct {
+		name   string
+		config dataloaden.LoaderConfig[int, int]
+	}{
+		{name: "read-lock", config: dataloaden.LoaderConfig[int, int]{Fetch: fetch}},
+		// the LRU cache has to take the write lock on every hit
+		{name: "write-lock", config: dataloaden.LoaderConfig[int, int]{Fetch: fetch, MaxCacheSize: n}},
+	}
+	for _, c := range configs {
+		b.Run(c.name, func(b *testing.B) {
+			loader := dataloaden.NewLoader(c.config)
+			for i := 0; i < n; i++ {
+				loader.Prime(i, i)
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					loader.Load(i % n)
+				}
+			})
+		})
+	}
+}
+
+func TestLoader_InflightDedup(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var fetched []int
+	fetch := func(keys []int) ([]int, []error) {
+		fetched = append(fetched, keys...)
+		close(started)
+		<-release
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	first := loader.LoadThunk(1)
+	<-started
+	second := loader.LoadThunk(1)
+	close(release)
+
+	for _, thunk := range []func() (int, error){first, second} {
+		if got, err := thunk(); err != nil || got != 10 {
+			t.Errorf("thunk() got = %v, %v, want %v, %v", got, err, 10, nil)
+		}
+	}
+	if want := []int{1}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_OnBatchSize(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	var mu sync.Mutex
+	var sizes []int
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:    fetch,
+		Wait:     1 * time.Millisecond,
+		MaxBatch: 3,
+		OnBatchSize: func(size int) {
+			mu.Lock()
+			sizes = append(sizes, size)
+			mu.Unlock()
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.LoadAll([]int{1, 2, 3, 4, 5, 6, 7})
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Ints(sizes)
+	if want := []int{1, 3, 3}; !reflect.DeepEqual(sizes, want) {
+		t.Errorf("OnBatchSize sizes = %v, want %v", sizes, want)
+	}
+}
+
+func TestLoader_MaxWeight(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]int
+	fetch := func(keys []int) ([]int, []error) {
+		mu.Lock()
+		batches = append(batches, keys)
+		mu.Unlock()
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:       fetch,
+		Wait:        1 * time.Millisecond,
+		BatchWeight: func(key int) int { return key },
+		MaxWeight:   10,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.LoadAll([]int{4, 5, 3, 7, 6, 1, 12})
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Slice(batches, func(i, j int) bool { return batches[i][0] < batches[j][0] })
+	// 3 would take [4 5] over 10, [3 7] reaches it, 12 would take [6 1] over 10 and is heavy enough alone
+	if want := [][]int{{3, 7}, {4, 5}, {6, 1}, {12}}; !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches = %v, want %v", batches, want)
+	}
+}
+
+func TestLoader_Dispatch(t *testing.T) {
+	var mu sync.Mutex
+	var fetched [][]int
+	fetch := func(keys []int) ([]int, []error) {
+		mu.Lock()
+		fetched = append(fetched, keys)
+		mu.Unlock()
+		ret := make([]int, len(keys))
+		for i, key := range keys {
+			ret[i] = key * 10
+		}
+		return ret, nil
+	}
+	// split keys by parity, fetch both halves in parallel and put the results back in order
+	dispatch := func(keys []int, fetch func([]int) ([]int, []error)) ([]int, []error) {
+		var shards [2][]int
+		var positions [2][]int
+		for i, key := range keys {
+			shards[key%2] = append(shards[key%2], key)
+			positions[key%2] = append(positions[key%2], i)
+		}
+		values := make([]int, len(keys))
+		var wg sync.WaitGroup
+		for shard := range shards {
+			wg.Add(1)
+			go func(shard int) {
+				defer wg.Done()
+				data, _ := fetch(shards[shard])
+				for i, pos := range positions[shard] {
+					values[pos] = data[i]
+				}
+			}(shard)
+		}
+		wg.Wait()
+		return values, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:    fetch,
+		Wait:     1 * time.Millisecond,
+		Dispatch: dispatch,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	got, _ := loader.LoadAll([]int{1, 2, 3, 4, 5})
+	if want := []int{10, 20, 30, 40, 50}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	sort.Slice(fetched, func(i, j int) bool { return fetched[i][0] < fetched[j][0] })
+	if want := [][]int{{1, 3, 5}, {2, 4}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_DispatchFound(t *testing.T) {
+	// even keys exist
+	fetchExists := func(_ context.Context, keys []int) ([]int, []bool, []error) {
+		found := make([]bool, len(keys))
+		for i, key := range keys {
+			found[i] = key%2 == 0
+		}
+		return keys, found, nil
+	}
+	fetchMap := func(keys []int) (map[int]int, map[int]error) {
+		ret := map[int]int{}
+		for _, key := range keys {
+			if key%2 == 0 {
+				ret[key] = key
+			}
+		}
+		return ret, nil
+	}
+	// fetches every key on its own, in reverse
+	dispatch := func(keys []int, fetch func([]int) ([]int, []error)) ([]int, []error) {
+		values := make([]int, len(keys))
+		for i := len(keys) - 1; i >= 0; i-- {
+			data, _ := fetch(keys[i : i+1])
+			values[i] = data[0]
+		}
+		return values, nil
+	}
+	tests := []struct {
+		name   string
+		config dataloaden.LoaderConfig[int, int]
+	}{
+		{name: "fetch exists", config: dataloaden.LoaderConfig[int, int]{FetchExists: fetchExists}},
+		{name: "fetch map", config: dataloaden.LoaderConfig[int, int]{FetchMap: fetchMap}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.Wait = 1 * time.Millisecond
+			config.Dispatch = dispatch
+			loader := dataloaden.NewLoader(config)
+
+			thunk1, thunk2 := loader.LoadThunk(1), loader.LoadThunk(2)
+			thunk1()
+			thunk2()
+			if _, found, err := loader.LoadExists(1); found || err != nil {
+				t.Errorf("LoadExists() got = %v, %v, want %v, %v", found, err, false, nil)
+			}
+			if got, found, err := loader.LoadExists(2); got != 2 || !found || err != nil {
+				t.Errorf("LoadExists() got = %v, %v, %v, want %v, %v, %v", got, found, err, 2, true, nil)
+			}
+		})
+	}
+}
+
+func TestLoader_MaxPendingBatches(t *testing.T) {
+	release := make(chan struct{})
+	fetch := func(keys []int) ([]int, []error) {
+		<-release
+		return keys, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:             fetch,
+		MaxBatch:          1,
+		MaxPendingBatches: 1,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	first := loader.LoadThunk(1)
+
+	// joining the batch being fetched doesn't need another one
+	joined := loader.LoadThunk(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := loader.LoadThunkContext(ctx, 2)(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("LoadThunkContext() err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	loaded := make(chan func() (int, error))
+	go func() { loaded <- loader.LoadThunk(3) }()
+	select {
+	case <-loaded:
+		t.Fatal("LoadThunk() returned over MaxPendingBatches")
+	case <-time.After(5 * time.Millisecond):
+	}
+
+	close(release)
+	if got, _ := first(); got != 1 {
+		t.Errorf("Load() got = %v, want %v", got, 1)
+	}
+	if got, _ := joined(); got != 1 {
+		t.Errorf("Load() got = %v, want %v", got, 1)
+	}
+	if got, _ := (<-loaded)(); got != 3 {
+		t.Errorf("Load() got = %v, want %v", got, 3)
+	}
+}
+
+func TestLoader_String(t *testing.T) {
+	release := make(chan struct{})
+	fetch := func(keys []int) ([]int, []error) {
+		<-release
+		return keys, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:    fetch,
+		Wait:     time.Hour,
+		MaxBatch: 2,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 1)
+	loader.Prime(2, 2)
+
+	if got, want := loader.String(), "Loader{cached: 2, pending: 0, fetching: 0, wait: 1h0m0s, maxBatch: 2}"; got != want {
+		t.Errorf("String() got = %v, want %v", got, want)
+	}
+
+	// a full batch is sent right away
+	full := loader.LoadAllThunk([]int{3, 4})
+	pending := loader.LoadThunk(5)
+	if got, want := loader.String(), "Loader{cached: 2, pending: 1, fetching: 1, wait: 1h0m0s, maxBatch: 2}"; got != want {
+		t.Errorf("String() got = %v, want %v", got, want)
+	}
+
+	close(release)
+	full()
+	loader.Flush()
+	pending()
+}
+
+func TestLoader_LoadOrStore(t *testing.T) {
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: func(keys []int) ([]int, []error) {
+			t.Errorf("fetch called with %v", keys)
+			return make([]int, len(keys)), nil
+		},
+		Wait: 1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	var computed int32
+	release := make(chan struct{})
+	compute := func() (int, error) {
+		atomic.AddInt32(&computed, 1)
+		<-release
+		return 100, nil
+	}
+	var wg sync.WaitGroup
+	got := make([]int, 10)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i], _ = loader.LoadOrStore(1, compute)
+		}(i)
+	}
+	time.Sleep(5 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&computed); got != 1 {
+		t.Errorf("compute called %v times, want %v", got, 1)
+	}
+	for i := range got {
+		if got[i] != 100 {
+			t.Errorf("LoadOrStore() got = %v, want %v", got[i], 100)
+		}
+	}
+	if got, _ := loader.Load(1); got != 100 {
+		t.Errorf("Load() got = %v, want %v", got, 100)
+	}
+
+	// failures are not stored
+	someErr := errors.New("some error")
+	if _, err := loader.LoadOrStore(2, func() (int, error) { return 0, someErr }); err != someErr {
+		t.Errorf("LoadOrStore() err = %v, want %v", err, someErr)
+	}
+	if got, _ := loader.LoadOrStore(2, func() (int, error) { return 200, nil }); got != 200 {
+		t.Errorf("LoadOrStore() got = %v, want %v", got, 200)
+	}
+}
+
+func TestLoader_LoadThunkCached(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 100)
+
+	tests := []struct {
+		name       string
+		key        int
+		want       int
+		wantCached bool
+	}{
+		{name: "primed", key: 1, want: 100, wantCached: true},
+		{name: "unprimed", key: 2, want: 20, wantCached: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			thunk, cached := loader.LoadThunkCached(tt.key)
+			if cached != tt.wantCached {
+				t.Errorf("LoadThunkCached() cached = %v, want %v", cached, tt.wantCached)
+			}
+			got, err := thunk()
+			if err != nil {
+				t.Errorf("LoadThunkCached() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("LoadThunkCached() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLoaderChecked(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	tests := []struct {
+		name    string
+		config  dataloaden.LoaderConfig[int, int]
+		wantErr bool
+	}{
+		{name: "valid", config: dataloaden.LoaderConfig[int, int]{Fetch: fetch, Wait: 1 * time.Millisecond, MaxBatch: 10}},
+		{name: "no fetch", config: dataloaden.LoaderConfig[int, int]{Wait: 1 * time.Millisecond}, wantErr: true},
+		{name: "negative wait", config: dataloaden.LoaderConfig[int, int]{Fetch: fetch, Wait: -1}, wantErr: true},
+		{name: "negative max batch", config: dataloaden.LoaderConfig[int, int]{Fetch: fetch, MaxBatch: -1}, wantErr: true},
+		{name: "equal without hash", config: dataloaden.LoaderConfig[int, int]{Fetch: fetch, Equal: func(a, b int) bool { return a == b }}, wantErr: true},
+		{name: "max cache bytes without size", config: dataloaden.LoaderConfig[int, int]{Fetch: fetch, MaxCacheBytes: 10}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader, err := dataloaden.NewLoaderChecked(tt.config)
+			if tt.wantErr {
+				if !errors.Is(err, dataloaden.ErrInvalidConfig) {
+					t.Errorf("NewLoaderChecked() error = %v, want %v", err, dataloaden.ErrInvalidConfig)
+				}
+				if loader != nil {
+					t.Errorf("NewLoaderChecked() got = %v, want nil", loader)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewLoaderChecked() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestLoader_Has(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		return make([]int, len(keys)), nil
+	}
+	clock := newFakeClock()
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		TTL:   20 * time.Millisecond,
+		Clock: clock,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 100)
+	loader.Prime(2, 200)
+	clock.Advance(30 * time.Millisecond)
+	loader.Prime(3, 300)
+
+	tests := []struct {
+		name string
+		key  int
+		want bool
+	}{
+		{name: "cached", key: 3, want: true},
+		{name: "uncached", key: 4, want: false},
+		{name: "expired", key: 1, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := loader.Has(tt.key); got != tt.want {
+				t.Errorf("Has() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := loader.PendingCount(); got != 0 {
+		t.Errorf("PendingCount() got = %v, want %v", got, 0)
+	}
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("fetch called %v times, want %v", got, 0)
+	}
+}
+
+func TestLoader_Reset(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Hour,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 100)
+	loader.Load(1)
+	pending := loader.LoadThunk(2)
+
+	loader.Reset()
+
+	if _, err := pending(); !errors.Is(err, dataloaden.ErrReset) {
+		t.Errorf("Load() error = %v, want %v", err, dataloaden.ErrReset)
+	}
+	if got := loader.Has(1); got {
+		t.Errorf("Has() got = %v, want %v", got, false)
+	}
+	if got := loader.PendingCount(); got != 0 {
+		t.Errorf("PendingCount() got = %v, want %v", got, 0)
+	}
+	if got, want := loader.Stats(), (dataloaden.Stats{}); got != want {
+		t.Errorf("Stats() got = %+v, want %+v", got, want)
+	}
+	if got := atomic.LoadInt32(&calls); got != 0 {
+		t.Errorf("fetch called %v times, want %v", got, 0)
+	}
+}
+
+func TestLoader_ResetWhileFetching(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(keys []int) ([]int, []error) {
+		close(started)
+		<-release
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	thunk := loader.LoadThunk(1)
+	<-started
+	loader.Reset()
+	close(release)
+
+	// the thunk still resolves, but the value from before the reset is not cached
+	if got, err := thunk(); err != nil || got != 10 {
+		t.Errorf("Load() got = %v, %v, want %v, %v", got, err, 10, nil)
+	}
+	if got := loader.Has(1); got {
+		t.Errorf("Has() got = %v, want %v", got, false)
+	}
+	if got := loader.Snapshot(); len(got) != 0 {
+		t.Errorf("Snapshot() got = %v, want empty", got)
+	}
+}
+
+func TestLoader_PrimeWhileFetching(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(keys []int) ([]int, []error) {
+		close(started)
+		<-release
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	thunk := loader.LoadThunk(1)
+	<-started
+	if want, got := true, loader.Prime(1, 1000); want != got {
+		t.Errorf("Prime() got = %v, want %v", got, want)
+	}
+	close(release)
+
+	if got, _ := thunk(); got != 10 {
+		t.Errorf("LoadThunk() got = %v, want %v", got, 10)
+	}
+	if got, _ := loader.Load(1); got != 1000 {
+		t.Errorf("Load() got = %v, want %v", got, 1000)
+	}
+}
+
+func TestLoader_LoadThunksAll(t *testing.T) {
+	var batches int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&batches, 1)
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	keys := []int{1, 2, 3, 2}
+	thunks := loader.LoadThunksAll(keys)
+	if len(thunks) != len(keys) {
+		t.Fatalf("LoadThunksAll() got %v thunks, want %v", len(thunks), len(keys))
+	}
+	for _, i := range []int{3, 0, 2, 1} {
+		got, err := thunks[i]()
+		if err != nil {
+			t.Errorf("thunk() error = %v", err)
+		}
+		if want := keys[i] * 10; got != want {
+			t.Errorf("thunk() got = %v, want %v", got, want)
+		}
+	}
+	if got := atomic.LoadInt32(&batches); got != 1 {
+		t.Errorf("fetch called %v times, want %v", got, 1)
+	}
+}
+
+func TestLoader_FetchTimeout(t *testing.T) {
+	hang := make(chan struct{})
+	defer close(hang)
+	fetch := func(keys []int) ([]int, []error) {
+		<-hang
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:        fetch,
+		Wait:         1 * time.Millisecond,
+		FetchTimeout: 10 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	_, errs := loader.LoadAll([]int{1, 2})
+	for _, err := range errs {
+		if !errors.Is(err, dataloaden.ErrFetchTimeout) {
+			t.Errorf("LoadAll() error = %v, want %v", err, dataloaden.ErrFetchTimeout)
+		}
+	}
+}
+
+func TestLoader_FetchTimeoutAbandoned(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			started <- struct{}{}
+			<-release
+		}
+		return keys, nil
+	}
+	results := make(chan error, 1)
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:        fetch,
+		Wait:         1 * time.Millisecond,
+		FetchTimeout: time.Hour,
+		CacheErrors:  true,
+		OnResult: func(_ int, _ int, err error) {
+			results <- err
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	thunk := loader.LoadThunkContext(ctx, 1)
+	<-started
+	cancel()
+	thunk()
+	// the batch every caller gave up on doesn't time out, and its error is not cached
+	if err := <-results; !errors.Is(err, context.Canceled) {
+		t.Errorf("OnResult() error = %v, want %v", err, context.Canceled)
+	}
+	got, err := loader.Load(1)
+	<-results
+	if err != nil || got != 1 {
+		t.Errorf("Load() got = %v, %v, want %v, %v", got, err, 1, nil)
+	}
+}
+
+func TestLoader_LoadTimeout(t *testing.T) {
+	fetched := make(chan error, 1)
+	fetch := func(ctx context.Context, keys []int) ([]int, []error) {
+		time.Sleep(20 * time.Millisecond)
+		fetched <- ctx.Err()
+		return keys, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		FetchContext: fetch,
+		Wait:         1 * time.Millisecond,
+		LoadTimeout:  5 * time.Millisecond,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	_, errs := loader.LoadAll([]int{1, 2})
+	for _, err := range errs {
+		if !errors.Is(err, dataloaden.ErrLoadTimeout) {
+			t.Errorf("LoadAll() error = %v, want %v", err, dataloaden.ErrLoadTimeout)
+		}
+	}
+
+	// the batch still completes for everyone else
+	if err := <-fetched; err != nil {
+		t.Errorf("fetch context error = %v, want nil", err)
+	}
+	if got, err := loader.Load(2); got != 2 || err != nil {
+		t.Errorf("Load() got = %v, %v, want %v, nil", got, err, 2)
+	}
+}
+
+func TestLoader_OnResult(t *testing.T) {
+	errOdd := errors.New("odd key")
+	fetch := func(keys []int) ([]int, []error) {
+		ret := make([]int, len(keys))
+		retErr := make([]error, len(keys))
+		for i := range keys {
+			if keys[i]%2 == 0 {
+				ret[i] = keys[i] * 10
+			} else {
+				retErr[i] = errOdd
+			}
+		}
+		return ret, retErr
+	}
+	type result struct {
+		value int
+		err   error
+	}
+	var mu sync.Mutex
+	results := map[int]result{}
+	byTen := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{Fetch: fetch})
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Millisecond,
+		OnResult: func(key int, value int, err error) {
+			mu.Lock()
+			results[key] = result{value: value, err: err}
+			mu.Unlock()
+			if err == nil {
+				byTen.Prime(value, key)
+			}
+		},
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.LoadAll([]int{1, 2, 4})
+
+	mu.Lock()
+	want := map[int]result{1: {err: errOdd}, 2: {value: 20}, 4: {value: 40}}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("OnResult results = %v, want %v", results, want)
+	}
+	mu.Unlock()
+	if got, _ := byTen.Load(40); got != 4 {
+		t.Errorf("Load() got = %v, want %v", got, 4)
+	}
+}
+
+func TestLoader_LoadAll_Chunks(t *testing.T) {
+	var mu sync.Mutex
+	var fetched [][]int
+	fetch := func(keys []int) ([]int, []error) {
+		mu.Lock()
+		fetched = append(fetched, keys)
+		mu.Unlock()
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:    fetch,
+		Wait:     1 * time.Hour,
+		MaxBatch: 3,
+	}
+	loader := dataloaden.NewLoader(config)
+	// a pending load the chunks must not be merged with
+	other := loader.LoadThunk(100)
+
+	keys := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	got, _ := loader.LoadAll(keys)
+	if want := []int{10, 20, 30, 40, 50, 60, 70, 80, 90, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+
+	mu.Lock()
+	sort.Slice(fetched, func(i, j int) bool { return fetched[i][0] < fetched[j][0] })
+	if want := [][]int{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}, {10}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+	mu.Unlock()
+	if got := loader.PendingCount(); got != 1 {
+		t.Errorf("PendingCount() got = %v, want %v", got, 1)
+	}
+	loader.Stop()
+	other()
+}
+
+func TestLoader_KeyLess(t *testing.T) {
+	var mu sync.Mutex
+	var fetched [][]int
+	errOdd := errors.New("odd key")
+	fetch := func(keys []int) ([]int, []error) {
+		mu.Lock()
+		fetched = append(fetched, keys)
+		mu.Unlock()
+		ret := make([]int, len(keys))
+		retErr := make([]error, len(keys))
+		for i := range keys {
+			if keys[i]%2 == 0 {
+				ret[i] = keys[i] * 10
+			} else {
+				retErr[i] = errOdd
+			}
+		}
+		return ret, retErr
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:   fetch,
+		Wait:    1 * time.Millisecond,
+		KeyLess: func(a, b int) bool { return a < b },
+	}
+	loader := dataloaden.NewLoader(config)
+
+	got, errs := loader.LoadAll([]int{4, 1, 8, 2})
+	if want := []int{40, 0, 80, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	if want := []error{nil, errOdd, nil, nil}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("LoadAll() errs = %v, want %v", errs, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := [][]int{{1, 2, 4, 8}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestLoader_Flush(t *testing.T) {
+	var calls int32
+	fetch := func(keys []int) ([]int, []error) {
+		atomic.AddInt32(&calls, 1)
+		ret := make([]int, len(keys))
+		for i := range keys {
+			ret[i] = keys[i] * 10
+		}
+		return ret, nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Hour,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	// nothing to flush
+	loader.Flush()
+
+	thunks := loader.LoadThunksAll([]int{1, 2})
+	loader.Flush()
+	loader.Flush()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("fetch called %v times, want %v", got, 1)
+	}
+	if got := loader.PendingCount(); got != 0 {
+		t.Errorf("PendingCount() got = %v, want %v", got, 0)
+	}
+	for i, thunk := range thunks {
+		if got, _ := thunk(); got != (i+1)*10 {
+			t.Errorf("thunk() got = %v, want %v", got, (i+1)*10)
+		}
+	}
+}
+
+func TestLoader_FetchMap(t *testing.T) {
+	errFailed := errors.New("failed")
+	errMissing := errors.New("missing")
+	fetch := func(keys []int) (map[int]int, map[int]error) {
+		values := map[int]int{}
+		errs := map[int]error{}
+		for _, key := range keys {
+			switch key % 3 {
+			case 0:
+				values[key] = key * 10
+			case 1:
+				errs[key] = errFailed
+			}
+			// keys%3 == 2 are left out
+		}
+		return values, errs
+	}
+	tests := []struct {
+		name     string
+		missing  error
+		want     []int
+		wantErrs []error
+	}{
+		{
+			name:     "missing keys are zero",
+			want:     []int{30, 0, 0, 60},
+			wantErrs: []error{nil, errFailed, nil, nil},
+		},
+		{
+			name:     "missing keys fail",
+			missing:  errMissing,
+			want:     []int{30, 0, 0, 60},
+			wantErrs: []error{nil, errFailed, errMissing, nil},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+				FetchMap:        fetch,
+				MissingKeyError: tt.missing,
+				Wait:            1 * time.Millisecond,
+			})
+			got, errs := loader.LoadAll([]int{3, 4, 5, 6})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadAll() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(errs, tt.wantErrs) {
+				t.Errorf("LoadAll() errs = %v, want %v", errs, tt.wantErrs)
+			}
+			if _, found, _ := loader.LoadExists(5); found {
+				t.Errorf("LoadExists() found = %v, want %v", found, false)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package dataloaden
+
+import "time"
+
+// Observer is told about what a Loader does, e.g. to export metrics, see LoaderConfig.Observer.
+// Its methods are called synchronously, so they must be quick, and may be called concurrently.
+type Observer[K comparable] interface {
+	// CacheHit is called for every load served from the cache
+	CacheHit(key K)
+
+	// CacheMiss is called for every load that has to wait for a batch
+	CacheMiss(key K)
+
+	// BatchDispatched is called after every batch is fetched with its keys,
+	// how long fetch took, and the errors it returned
+	BatchDispatched(keys []K, duration time.Duration, errs []error)
+
+	// FetchPanic is called with the recovered value when fetch panics
+	FetchPanic(recovered any)
+}
+
+// NoopObserver is an Observer that does nothing. It can be embedded to implement only some methods.
+type NoopObserver[K comparable] struct{}
+
+func (NoopObserver[K]) CacheHit(K)                                  {}
+func (NoopObserver[K]) CacheMiss(K)                                 {}
+func (NoopObserver[K]) BatchDispatched([]K, time.Duration, []error) {}
+func (NoopObserver[K]) FetchPanic(any)                              {}
+
+// MultiObserver is an Observer telling every one of its observers, in order
+type MultiObserver[K comparable] []Observer[K]
+
+func (m MultiObserver[K]) CacheHit(key K) {
+	for _, o := range m {
+		o.CacheHit(key)
+	}
+}
+
+func (m MultiObserver[K]) CacheMiss(key K) {
+	for _, o := range m {
+		o.CacheMiss(key)
+	}
+}
+
+func (m MultiObserver[K]) BatchDispatched(keys []K, duration time.Duration, errs []error) {
+	for _, o := range m {
+		o.BatchDispatched(keys, duration, errs)
+	}
+}
+
+func (m MultiObserver[K]) FetchPanic(recovered any) {
+	for _, o := range m {
+		o.FetchPanic(recovered)
+	}
+}
@@ -0,0 +1,78 @@
+package dataloaden_test
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (o *recordingObserver) record(format string, args ...any) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, fmt.Sprintf(format, args...))
+}
+
+func (o *recordingObserver) CacheHit(key int) { o.record("hit %v", key) }
+
+func (o *recordingObserver) CacheMiss(key int) { o.record("miss %v", key) }
+
+func (o *recordingObserver) BatchDispatched(keys []int, _ time.Duration, errs []error) {
+	o.record("batch %v %v", keys, len(errs))
+}
+
+func (o *recordingObserver) FetchPanic(recovered any) { o.record("panic %v", recovered) }
+
+// missObserver only counts misses
+type missObserver struct {
+	dataloaden.NoopObserver[int]
+	misses int
+}
+
+func (o *missObserver) CacheMiss(int) { o.misses++ }
+
+func TestLoader_Observer(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		if keys[0] == 3 {
+			panic("boom")
+		}
+		return make([]int, len(keys)), nil
+	}
+	recording := &recordingObserver{}
+	misses := &missObserver{}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:    fetch,
+		Wait:     1 * time.Millisecond,
+		Observer: dataloaden.MultiObserver[int]{recording, misses},
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(1, 100)
+
+	loader.LoadAll([]int{1, 2})
+	loader.Load(2)
+	loader.Load(3)
+
+	want := []string{
+		"hit 1",
+		"miss 2",
+		"batch [2] 0",
+		"hit 2",
+		"miss 3",
+		"panic boom",
+		"batch [3] 1",
+	}
+	if !reflect.DeepEqual(recording.events, want) {
+		t.Errorf("events = %q, want %q", recording.events, want)
+	}
+	if want := 2; misses.misses != want {
+		t.Errorf("misses = %v, want %v", misses.misses, want)
+	}
+}
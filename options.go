@@ -0,0 +1,188 @@
+package dataloaden
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Option sets a field of the LoaderConfig of a loader created by NewLoaderWithOptions.
+// Options that don't take keys or values apply to a loader of any type, so they need no type arguments.
+// The others only apply to a loader of their key and value types.
+type Option interface {
+	apply(o *options)
+}
+
+// options collects the fields of LoaderConfig set by the options of NewLoaderWithOptions
+type options struct {
+	wait                 time.Duration
+	waitJitter           time.Duration
+	maxWait              time.Duration
+	maxBatch             int
+	ttl                  time.Duration
+	maxCacheSize         int
+	cacheErrors          bool
+	negativeTTL          time.Duration
+	maxConcurrentBatches int
+	maxRetries           int
+	retryBackoff         time.Duration
+	clock                Clock
+	scheduler            *Scheduler
+
+	// the options of a key and value type, each a configOption, in order
+	typed []Option
+}
+
+// optionFunc is an Option for a loader of any type
+type optionFunc func(o *options)
+
+func (f optionFunc) apply(o *options) { f(o) }
+
+// configOption is an Option for a loader of keys K and values V
+type configOption[K comparable, V any] func(config *LoaderConfig[K, V])
+
+func (f configOption[K, V]) apply(o *options) { o.typed = append(o.typed, f) }
+
+// NewLoaderWithOptions creates a new Loader given a fetch and options, leaving every field
+// no option sets at its zero value. fetch may be nil if an option sets another fetch.
+// See LoaderConfig for what each option does. It panics if the config is invalid or an option
+// is for another key or value type, see NewLoaderWithOptionsChecked.
+func NewLoaderWithOptions[K comparable, V any](fetch func(keys []K) ([]V, []error), opts ...Option) *Loader[K, V] {
+	l, err := NewLoaderWithOptionsChecked(fetch, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// NewLoaderWithOptionsChecked is like NewLoaderWithOptions but returns an error wrapping
+// ErrInvalidConfig instead of panicking, see NewLoaderChecked.
+func NewLoaderWithOptionsChecked[K comparable, V any](fetch func(keys []K) ([]V, []error), opts ...Option) (*Loader[K, V], error) {
+	var o options
+	for _, opt := range opts {
+		opt.apply(&o)
+	}
+	config := LoaderConfig[K, V]{
+		Fetch:                fetch,
+		Wait:                 o.wait,
+		WaitJitter:           o.waitJitter,
+		MaxWait:              o.maxWait,
+		MaxBatch:             o.maxBatch,
+		TTL:                  o.ttl,
+		MaxCacheSize:         o.maxCacheSize,
+		CacheErrors:          o.cacheErrors,
+		NegativeTTL:          o.negativeTTL,
+		MaxConcurrentBatches: o.maxConcurrentBatches,
+		MaxRetries:           o.maxRetries,
+		RetryBackoff:         o.retryBackoff,
+		Clock:                o.clock,
+		Scheduler:            o.scheduler,
+	}
+	for _, opt := range o.typed {
+		set, ok := opt.(configOption[K, V])
+		if !ok {
+			return nil, fmt.Errorf("%w: option %T is for another key or value type", ErrInvalidConfig, opt)
+		}
+		set(&config)
+	}
+	return NewLoaderChecked(config)
+}
+
+// WithWait sets LoaderConfig.Wait
+func WithWait(wait time.Duration) Option {
+	return optionFunc(func(o *options) { o.wait = wait })
+}
+
+// WithWaitJitter sets LoaderConfig.WaitJitter
+func WithWaitJitter(jitter time.Duration) Option {
+	return optionFunc(func(o *options) { o.waitJitter = jitter })
+}
+
+// WithMaxWait sets LoaderConfig.MaxWait
+func WithMaxWait(maxWait time.Duration) Option {
+	return optionFunc(func(o *options) { o.maxWait = maxWait })
+}
+
+// WithMaxBatch sets LoaderConfig.MaxBatch
+func WithMaxBatch(maxBatch int) Option {
+	return optionFunc(func(o *options) { o.maxBatch = maxBatch })
+}
+
+// WithTTL sets LoaderConfig.TTL
+func WithTTL(ttl time.Duration) Option {
+	return optionFunc(func(o *options) { o.ttl = ttl })
+}
+
+// WithCache sets LoaderConfig.Cache
+func WithCache[K comparable, V any](cache Cache[K, V]) Option {
+	return configOption[K, V](func(config *LoaderConfig[K, V]) { config.Cache = cache })
+}
+
+// WithMaxCacheSize sets LoaderConfig.MaxCacheSize
+func WithMaxCacheSize(size int) Option {
+	return optionFunc(func(o *options) { o.maxCacheSize = size })
+}
+
+// WithCacheErrors sets LoaderConfig.CacheErrors
+func WithCacheErrors() Option {
+	return optionFunc(func(o *options) { o.cacheErrors = true })
+}
+
+// WithNegativeTTL sets LoaderConfig.NegativeTTL
+func WithNegativeTTL(ttl time.Duration) Option {
+	return optionFunc(func(o *options) { o.negativeTTL = ttl })
+}
+
+// WithMaxConcurrentBatches sets LoaderConfig.MaxConcurrentBatches
+func WithMaxConcurrentBatches(n int) Option {
+	return optionFunc(func(o *options) { o.maxConcurrentBatches = n })
+}
+
+// WithRetries sets LoaderConfig.MaxRetries and LoaderConfig.RetryBackoff
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.maxRetries = maxRetries
+		o.retryBackoff = backoff
+	})
+}
+
+// WithClock sets LoaderConfig.Clock
+func WithClock(clock Clock) Option {
+	return optionFunc(func(o *options) { o.clock = clock })
+}
+
+// WithScheduler sets LoaderConfig.Scheduler
+func WithScheduler(scheduler *Scheduler) Option {
+	return optionFunc(func(o *options) { o.scheduler = scheduler })
+}
+
+// WithFetchContext sets LoaderConfig.FetchContext, replacing the fetch passed to NewLoaderWithOptions
+func WithFetchContext[K comparable, V any](fetch func(ctx context.Context, keys []K) ([]V, []error)) Option {
+	return configOption[K, V](func(config *LoaderConfig[K, V]) {
+		config.Fetch = nil
+		config.FetchContext = fetch
+	})
+}
+
+// WithFetchMap sets LoaderConfig.FetchMap, replacing the fetch passed to NewLoaderWithOptions
+func WithFetchMap[K comparable, V any](fetch func(keys []K) (map[K]V, map[K]error)) Option {
+	return configOption[K, V](func(config *LoaderConfig[K, V]) {
+		config.Fetch = nil
+		config.FetchMap = fetch
+	})
+}
+
+// WithDispatch sets LoaderConfig.Dispatch
+func WithDispatch[K comparable, V any](dispatch func(keys []K, fetch func([]K) ([]V, []error)) ([]V, []error)) Option {
+	return configOption[K, V](func(config *LoaderConfig[K, V]) { config.Dispatch = dispatch })
+}
+
+// WithOnEvict sets LoaderConfig.OnEvict
+func WithOnEvict[K comparable, V any](onEvict func(key K, value V)) Option {
+	return configOption[K, V](func(config *LoaderConfig[K, V]) { config.OnEvict = onEvict })
+}
+
+// WithOnBatch sets LoaderConfig.OnBatch
+func WithOnBatch[K comparable, V any](onBatch func(keys []K, duration time.Duration, err []error)) Option {
+	return configOption[K, V](func(config *LoaderConfig[K, V]) { config.OnBatch = onBatch })
+}
@@ -0,0 +1,137 @@
+package dataloaden_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestNewLoaderWithOptions(t *testing.T) {
+	type result struct {
+		values  []int
+		batches int32
+	}
+	tests := []struct {
+		name string
+		opts []dataloaden.Option
+		want result
+	}{
+		{
+			name: "wait",
+			opts: []dataloaden.Option{dataloaden.WithWait(1 * time.Millisecond)},
+			want: result{values: []int{10, 20, 30, 40}, batches: 1},
+		},
+		{
+			name: "wait and max batch",
+			opts: []dataloaden.Option{
+				dataloaden.WithWait(1 * time.Millisecond),
+				dataloaden.WithMaxBatch(2),
+			},
+			want: result{values: []int{10, 20, 30, 40}, batches: 2},
+		},
+		{
+			name: "cache and max cache size",
+			opts: []dataloaden.Option{
+				dataloaden.WithWait(1 * time.Millisecond),
+				dataloaden.WithCache[int, int](&syncMapCache[int, int]{}),
+				dataloaden.WithMaxCacheSize(1),
+			},
+			want: result{values: []int{10, 20, 30, 40}, batches: 1},
+		},
+		{
+			name: "fetch map",
+			opts: []dataloaden.Option{
+				dataloaden.WithWait(1 * time.Millisecond),
+				dataloaden.WithFetchMap(func(keys []int) (map[int]int, map[int]error) {
+					ret := map[int]int{}
+					for _, key := range keys {
+						ret[key] = key
+					}
+					return ret, nil
+				}),
+			},
+			want: result{values: []int{1, 2, 3, 4}},
+		},
+		{
+			name: "dispatch",
+			opts: []dataloaden.Option{
+				dataloaden.WithWait(1 * time.Millisecond),
+				dataloaden.WithDispatch(func(keys []int, fetch func([]int) ([]int, []error)) ([]int, []error) {
+					// every key on its own
+					values := make([]int, len(keys))
+					for i := range keys {
+						data, _ := fetch(keys[i : i+1])
+						values[i] = data[0]
+					}
+					return values, nil
+				}),
+			},
+			want: result{values: []int{10, 20, 30, 40}, batches: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var batches int32
+			fetch := func(keys []int) ([]int, []error) {
+				atomic.AddInt32(&batches, 1)
+				ret := make([]int, len(keys))
+				for i := range keys {
+					ret[i] = keys[i] * 10
+				}
+				return ret, nil
+			}
+			loader := dataloaden.NewLoaderWithOptions(fetch, tt.opts...)
+
+			values, _ := loader.LoadAll([]int{1, 2, 3, 4})
+			// every value is cached, so loading them again fetches nothing
+			loader.LoadAll([]int{1, 2, 3, 4})
+
+			got := result{values: values, batches: atomic.LoadInt32(&batches)}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewLoaderWithOptions() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewLoaderWithOptionsChecked(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return keys, nil
+	}
+	fetchContext := func(_ context.Context, keys []int) ([]int, []error) {
+		return keys, nil
+	}
+	tests := []struct {
+		name    string
+		fetch   func(keys []int) ([]int, []error)
+		opts    []dataloaden.Option
+		wantErr bool
+	}{
+		{name: "valid", fetch: fetch, opts: []dataloaden.Option{dataloaden.WithWait(1 * time.Millisecond)}},
+		{name: "fetch context", opts: []dataloaden.Option{dataloaden.WithFetchContext(fetchContext)}},
+		{name: "no fetch", wantErr: true},
+		{name: "negative wait", fetch: fetch, opts: []dataloaden.Option{dataloaden.WithWait(-1)}, wantErr: true},
+		{
+			name:    "other key type",
+			fetch:   fetch,
+			opts:    []dataloaden.Option{dataloaden.WithOnEvict(func(key string, value int) {})},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := dataloaden.NewLoaderWithOptionsChecked(tt.fetch, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewLoaderWithOptionsChecked() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, dataloaden.ErrInvalidConfig) {
+				t.Errorf("NewLoaderWithOptionsChecked() error = %v, want %v", err, dataloaden.ErrInvalidConfig)
+			}
+		})
+	}
+}
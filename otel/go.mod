@@ -0,0 +1,25 @@
+module github.com/Warashi/dataloaden/otel
+
+go 1.25.0
+
+require (
+	github.com/Warashi/dataloaden v0.0.0-20261016105854-5bcdb5b2a457
+	go.opentelemetry.io/otel v1.46.0
+	go.opentelemetry.io/otel/sdk v1.46.0
+	go.opentelemetry.io/otel/trace v1.46.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/go-logr/logr v1.4.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	go.opentelemetry.io/auto/sdk v1.2.1 // indirect
+	go.opentelemetry.io/otel/metric v1.46.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+)
+
+// develop and test against the dataloaden next to this module. the replace is ignored by
+// modules requiring this one, which get the dataloaden required above: a commit with every
+// API this module uses, to be bumped to a tag once dataloaden is released with Observer.
+replace github.com/Warashi/dataloaden => ../
@@ -0,0 +1,101 @@
+// Package otel records a span for every batch of a dataloaden.Loader with OpenTelemetry.
+// It is a module of its own, so dataloaden itself doesn't depend on OpenTelemetry,
+// and it needs the Go version OpenTelemetry does, which is newer than dataloaden's.
+//
+// A batch is shared by the loads of many callers, and Observer isn't passed their contexts,
+// so the spans are roots of traces of their own: they are not parented to the callers' spans.
+package otel
+
+import (
+	"context"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName is the name of the tracer the spans are recorded with
+const instrumentationName = "github.com/Warashi/dataloaden/otel"
+
+// Observer is a dataloaden.Observer recording a span for every batch, see NewObserver
+type Observer[K comparable] struct {
+	dataloaden.NoopObserver[K]
+
+	tracer   trace.Tracer
+	spanName string
+}
+
+// Option configures an Observer
+type Option func(*config)
+
+type config struct {
+	provider trace.TracerProvider
+	spanName string
+}
+
+// WithTracerProvider sets the TracerProvider spans are recorded with, the global one by default
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.provider = provider
+	}
+}
+
+// WithSpanName sets the name of the spans, "dataloaden.batch" by default.
+// it tells loaders apart when several share a TracerProvider.
+func WithSpanName(name string) Option {
+	return func(c *config) {
+		c.spanName = name
+	}
+}
+
+// NewObserver creates an Observer to set as LoaderConfig.Observer. Every batch is recorded as a span
+// covering its fetch, with the number of keys and of failed keys as attributes. the span has an error
+// status, and records the first error, when any key fails.
+func NewObserver[K comparable](opts ...Option) *Observer[K] {
+	c := config{spanName: "dataloaden.batch"}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.provider == nil {
+		c.provider = otel.GetTracerProvider()
+	}
+	return &Observer[K]{
+		tracer:   c.provider.Tracer(instrumentationName),
+		spanName: c.spanName,
+	}
+}
+
+// BatchDispatched records the span of a batch once it is fetched, as the root of a trace of its own
+func (o *Observer[K]) BatchDispatched(keys []K, duration time.Duration, errs []error) {
+	end := time.Now()
+	_, span := o.tracer.Start(context.Background(), o.spanName,
+		trace.WithTimestamp(end.Add(-duration)),
+		trace.WithSpanKind(trace.SpanKindInternal),
+	)
+	var failed int
+	var first error
+	for _, err := range errs {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	if len(errs) == 1 && len(keys) > 1 && first != nil {
+		// a single error fails every key
+		failed = len(keys)
+	}
+	span.SetAttributes(
+		attribute.Int("dataloaden.batch.keys", len(keys)),
+		attribute.Int("dataloaden.batch.errors", failed),
+	)
+	if first != nil {
+		span.RecordError(first)
+		span.SetStatus(codes.Error, first.Error())
+	}
+	span.End(trace.WithTimestamp(end))
+}
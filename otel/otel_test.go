@@ -0,0 +1,70 @@
+package otel_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+	dataloadenotel "github.com/Warashi/dataloaden/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestObserver(t *testing.T) {
+	errOdd := errors.New("odd key")
+	fetch := func(keys []int) ([]int, []error) {
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			if key%2 == 1 {
+				errs[i] = errOdd
+			}
+		}
+		return make([]int, len(keys)), errs
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:    fetch,
+		Wait:     1 * time.Millisecond,
+		Observer: dataloadenotel.NewObserver[int](dataloadenotel.WithTracerProvider(provider), dataloadenotel.WithSpanName("users")),
+	}
+	loader := dataloaden.NewLoader(config)
+
+	loader.LoadAll([]int{2, 4})
+	loader.LoadAll([]int{1, 2, 3, 6})
+
+	tests := []struct {
+		keys   int64
+		errors int64
+		status codes.Code
+	}{
+		{keys: 2, errors: 0, status: codes.Unset},
+		{keys: 3, errors: 2, status: codes.Error},
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != len(tests) {
+		t.Fatalf("got %v spans, want %v", len(spans), len(tests))
+	}
+	for i, tt := range tests {
+		span := spans[i]
+		if span.Name != "users" {
+			t.Errorf("span[%d] name = %v, want %v", i, span.Name, "users")
+		}
+		attrs := attribute.NewSet(span.Attributes...)
+		if got, _ := attrs.Value("dataloaden.batch.keys"); got.AsInt64() != tt.keys {
+			t.Errorf("span[%d] keys = %v, want %v", i, got.AsInt64(), tt.keys)
+		}
+		if got, _ := attrs.Value("dataloaden.batch.errors"); got.AsInt64() != tt.errors {
+			t.Errorf("span[%d] errors = %v, want %v", i, got.AsInt64(), tt.errors)
+		}
+		if span.Status.Code != tt.status {
+			t.Errorf("span[%d] status = %v, want %v", i, span.Status.Code, tt.status)
+		}
+		if span.EndTime.Before(span.StartTime) {
+			t.Errorf("span[%d] ends at %v, before it starts at %v", i, span.EndTime, span.StartTime)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package dataloaden
+
+import "context"
+
+// registryKey is the context key of the Registry attached to a context
+type registryKey struct{}
+
+// Registry holds the loaders attached to a context by name, see Attach and For.
+// It is never modified once attached, attaching a loader copies it.
+type Registry struct {
+	loaders map[string]any
+}
+
+// registryFrom returns the Registry attached to ctx, or nil if there is none
+func registryFrom(ctx context.Context) *Registry {
+	r, _ := ctx.Value(registryKey{}).(*Registry)
+	return r
+}
+
+// Attach returns a copy of ctx holding loader by name, e.g. in a middleware creating the loaders of a request.
+// A loader attached before with the same name is replaced.
+func Attach[K comparable, V any](ctx context.Context, name string, loader *Loader[K, V]) context.Context {
+	r := &Registry{loaders: map[string]any{name: loader}}
+	if parent := registryFrom(ctx); parent != nil {
+		for n, l := range parent.loaders {
+			if n != name {
+				r.loaders[n] = l
+			}
+		}
+	}
+	return context.WithValue(ctx, registryKey{}, r)
+}
+
+// For returns the loader attached to ctx by name, or nil if there is none or it is of another type
+func For[K comparable, V any](ctx context.Context, name string) *Loader[K, V] {
+	r := registryFrom(ctx)
+	if r == nil {
+		return nil
+	}
+	loader, _ := r.loaders[name].(*Loader[K, V])
+	return loader
+}
@@ -0,0 +1,57 @@
+package dataloaden_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestRegistry(t *testing.T) {
+	users := dataloaden.NewLoader(dataloaden.LoaderConfig[int, string]{
+		Fetch: func(keys []int) ([]string, []error) {
+			ret := make([]string, len(keys))
+			for i, key := range keys {
+				ret[i] = "user " + strconv.Itoa(key)
+			}
+			return ret, nil
+		},
+		Wait: 1 * time.Millisecond,
+	})
+	posts := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+		Fetch: func(keys []int) ([]int, []error) { return make([]int, len(keys)), nil },
+		Wait:  1 * time.Millisecond,
+	})
+
+	ctx := dataloaden.Attach(context.Background(), "users", users)
+	ctx = dataloaden.Attach(ctx, "posts", posts)
+
+	if got := dataloaden.For[int, string](ctx, "users"); got != users {
+		t.Errorf("For() got = %p, want %p", got, users)
+	}
+	if got := dataloaden.For[int, int](ctx, "posts"); got != posts {
+		t.Errorf("For() got = %p, want %p", got, posts)
+	}
+	if got, _ := dataloaden.For[int, string](ctx, "users").Load(1); got != "user 1" {
+		t.Errorf("Load() got = %v, want %v", got, "user 1")
+	}
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		key  string
+	}{
+		{name: "no-registry", ctx: context.Background(), key: "users"},
+		{name: "missing-name", ctx: ctx, key: "comments"},
+		{name: "wrong-type", ctx: ctx, key: "posts"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dataloaden.For[int, string](tt.ctx, tt.key); got != nil {
+				t.Errorf("For() got = %p, want nil", got)
+			}
+		})
+	}
+}
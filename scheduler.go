@@ -0,0 +1,133 @@
+package dataloaden
+
+import (
+	"sync"
+	"time"
+)
+
+// Scheduler dispatches the batches of many loaders from a single goroutine driven by one ticker,
+// instead of starting a timer for every batch. It is meant to be shared by many short-lived
+// loaders, see LoaderConfig.Scheduler.
+//
+// Waits are rounded up to a whole number of ticks, so tick should be small compared to the
+// Wait of the loaders using it. A Scheduler must be stopped with Stop when it is no longer used.
+type Scheduler struct {
+	tick time.Duration
+
+	// number of ticks since the scheduler started
+	now uint64
+
+	// timers by the tick they are due at
+	due map[uint64][]*schedulerTimer
+
+	stop chan struct{}
+	done chan struct{}
+
+	stopped bool
+	mu      sync.Mutex
+}
+
+// NewScheduler creates a Scheduler ticking every tick, and starts its goroutine
+func NewScheduler(tick time.Duration) *Scheduler {
+	s := &Scheduler{
+		tick: tick,
+		due:  map[uint64][]*schedulerTimer{},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go s.run(time.NewTicker(tick))
+	return s
+}
+
+// AfterFunc calls f on the scheduler's goroutine once d has passed, rounded up to the next tick.
+// f must not block, since it holds up every other timer of the scheduler.
+// If the scheduler is stopped, f is called right away in its own goroutine instead.
+func (s *Scheduler) AfterFunc(d time.Duration, f func()) Timer {
+	t := &schedulerTimer{s: s, f: f}
+	s.mu.Lock()
+	if s.stopped {
+		t.fired = true
+		s.mu.Unlock()
+		go f()
+		return t
+	}
+	ticks := uint64((d + s.tick - 1) / s.tick)
+	if ticks == 0 {
+		ticks = 1
+	}
+	at := s.now + ticks
+	s.due[at] = append(s.due[at], t)
+	s.mu.Unlock()
+	return t
+}
+
+// Stop stops the scheduler's goroutine, calling every pending timer right away so no batch
+// is left waiting. It is safe to call Stop more than once.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		<-s.done
+		return
+	}
+	s.stopped = true
+	s.mu.Unlock()
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Scheduler) run(ticker *time.Ticker) {
+	defer close(s.done)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			s.now++
+			due := s.due[s.now]
+			delete(s.due, s.now)
+			s.mu.Unlock()
+			s.fire(due)
+		case <-s.stop:
+			s.mu.Lock()
+			var due []*schedulerTimer
+			for at, timers := range s.due {
+				due = append(due, timers...)
+				delete(s.due, at)
+			}
+			s.mu.Unlock()
+			s.fire(due)
+			return
+		}
+	}
+}
+
+// fire calls the timers that haven't been stopped
+func (s *Scheduler) fire(timers []*schedulerTimer) {
+	for _, t := range timers {
+		s.mu.Lock()
+		fire := !t.fired
+		t.fired = true
+		s.mu.Unlock()
+		if fire {
+			t.f()
+		}
+	}
+}
+
+// schedulerTimer is a Timer of a Scheduler
+type schedulerTimer struct {
+	s *Scheduler
+	f func()
+
+	// set once f is called or the timer is stopped
+	fired bool
+}
+
+func (t *schedulerTimer) Stop() bool {
+	t.s.mu.Lock()
+	defer t.s.mu.Unlock()
+	stopped := !t.fired
+	t.fired = true
+	return stopped
+}
@@ -0,0 +1,119 @@
+package dataloaden_test
+
+import (
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestScheduler(t *testing.T) {
+	scheduler := dataloaden.NewScheduler(1 * time.Millisecond)
+	defer scheduler.Stop()
+
+	var mu sync.Mutex
+	fetched := map[string][][]int{}
+	newLoader := func(name string) *dataloaden.Loader[int, int] {
+		return dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+			Fetch: func(keys []int) ([]int, []error) {
+				mu.Lock()
+				sorted := append([]int(nil), keys...)
+				sort.Ints(sorted)
+				fetched[name] = append(fetched[name], sorted)
+				mu.Unlock()
+				ret := make([]int, len(keys))
+				for i := range keys {
+					ret[i] = keys[i] * 10
+				}
+				return ret, nil
+			},
+			Wait:      5 * time.Millisecond,
+			Scheduler: scheduler,
+		})
+	}
+	a, b := newLoader("a"), newLoader("b")
+
+	thunksA := a.LoadAllThunk([]int{1, 2, 3})
+	thunksB := b.LoadAllThunk([]int{4, 5})
+	gotA, _ := thunksA()
+	gotB, _ := thunksB()
+
+	if want := []int{10, 20, 30}; !reflect.DeepEqual(gotA, want) {
+		t.Errorf("LoadAll() got = %v, want %v", gotA, want)
+	}
+	if want := []int{40, 50}; !reflect.DeepEqual(gotB, want) {
+		t.Errorf("LoadAll() got = %v, want %v", gotB, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := map[string][][]int{"a": {{1, 2, 3}}, "b": {{4, 5}}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %v, want %v", fetched, want)
+	}
+}
+
+func TestScheduler_Stop(t *testing.T) {
+	scheduler := dataloaden.NewScheduler(1 * time.Millisecond)
+	loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+		Fetch: func(keys []int) ([]int, []error) {
+			return make([]int, len(keys)), nil
+		},
+		Wait:      1 * time.Hour,
+		Scheduler: scheduler,
+	})
+
+	thunk := loader.LoadThunk(1)
+	scheduler.Stop()
+	if _, err := thunk(); err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+
+	// loaders keep working once the scheduler is stopped
+	if _, err := loader.Load(2); err != nil {
+		t.Errorf("Load() error = %v", err)
+	}
+}
+
+func BenchmarkScheduler(b *testing.B) {
+	const loaders = 1000
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	scheduler := dataloaden.NewScheduler(1 * time.Millisecond)
+	defer scheduler.Stop()
+
+	tests := []struct {
+		name      string
+		scheduler *dataloaden.Scheduler
+	}{
+		{name: "timer per batch"},
+		{name: "shared scheduler", scheduler: scheduler},
+	}
+	for _, tt := range tests {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var goroutines int
+			for n := 0; n < b.N; n++ {
+				thunks := make([]func() (int, error), loaders)
+				for i := range thunks {
+					loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+						Fetch:     fetch,
+						Wait:      2 * time.Millisecond,
+						Scheduler: tt.scheduler,
+					})
+					thunks[i] = loader.LoadThunk(i)
+				}
+				if g := runtime.NumGoroutine(); g > goroutines {
+					goroutines = g
+				}
+				for _, thunk := range thunks {
+					thunk()
+				}
+			}
+			b.ReportMetric(float64(goroutines), "goroutines")
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package dataloaden
+
+import (
+	"runtime/debug"
+	"sync"
+)
+
+// FetchFromSingle adapts a function loading a single key into a Fetch, calling it for each key in turn.
+func FetchFromSingle[K comparable, V any](single func(key K) (V, error)) func(keys []K) ([]V, []error) {
+	return func(keys []K) ([]V, []error) {
+		data := make([]V, len(keys))
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			data[i], errs[i] = single(key)
+		}
+		return data, errs
+	}
+}
+
+// FetchFromSingleConcurrent is like FetchFromSingle but calls single for up to workers keys at the same time.
+// single runs on goroutines of its own, so a panic in it fails its key with a *PanicError.
+func FetchFromSingleConcurrent[K comparable, V any](single func(key K) (V, error), workers int) func(keys []K) ([]V, []error) {
+	if workers < 1 {
+		workers = 1
+	}
+	return func(keys []K) ([]V, []error) {
+		data := make([]V, len(keys))
+		errs := make([]error, len(keys))
+		next := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers && w < len(keys); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					data[i], errs[i] = callSingle(single, keys[i])
+				}
+			}()
+		}
+		for i := range keys {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+		return data, errs
+	}
+}
+
+// callSingle calls single with key, turning a panic into a *PanicError
+func callSingle[K comparable, V any](single func(key K) (V, error), key K) (data V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return single(key)
+}
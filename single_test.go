@@ -0,0 +1,86 @@
+package dataloaden_test
+
+import (
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestFetchFromSingle(t *testing.T) {
+	errOdd := errors.New("odd key")
+	var running, peak int32
+	single := func(key int) (int, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(1 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		if key%2 != 0 {
+			return 0, errOdd
+		}
+		return key * 10, nil
+	}
+
+	tests := []struct {
+		name     string
+		fetch    func(keys []int) ([]int, []error)
+		wantPeak int32
+	}{
+		{name: "sequential", fetch: dataloaden.FetchFromSingle(single), wantPeak: 1},
+		{name: "concurrent", fetch: dataloaden.FetchFromSingleConcurrent(single, 3), wantPeak: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&peak, 0)
+			loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+				Fetch: tt.fetch,
+				Wait:  1 * time.Millisecond,
+			})
+			got, errs := loader.LoadAll([]int{0, 1, 2, 3, 4, 5, 6, 7})
+			if want := []int{0, 0, 20, 0, 40, 0, 60, 0}; !reflect.DeepEqual(got, want) {
+				t.Errorf("LoadAll() got = %v, want %v", got, want)
+			}
+			for i, err := range errs {
+				if wantErr := i%2 != 0; (err != nil) != wantErr {
+					t.Errorf("LoadAll() error[%d] = %v, wantErr %v", i, err, wantErr)
+				}
+			}
+			if got := atomic.LoadInt32(&peak); got > tt.wantPeak {
+				t.Errorf("peak concurrency = %v, want at most %v", got, tt.wantPeak)
+			}
+		})
+	}
+}
+
+func TestFetchFromSingleConcurrent_Panic(t *testing.T) {
+	single := func(key int) (int, error) {
+		if key == 1 {
+			panic("boom")
+		}
+		return key * 10, nil
+	}
+	loader := dataloaden.NewLoader(dataloaden.LoaderConfig[int, int]{
+		Fetch: dataloaden.FetchFromSingleConcurrent(single, 2),
+		Wait:  1 * time.Millisecond,
+	})
+
+	got, errs := loader.LoadAll([]int{0, 1, 2})
+	if want := []int{0, 0, 20}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadAll() got = %v, want %v", got, want)
+	}
+	var panicErr *dataloaden.PanicError
+	if !errors.As(errs[1], &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("LoadAll() error[1] = %v, want a *PanicError", errs[1])
+	}
+	if errs[0] != nil || errs[2] != nil {
+		t.Errorf("LoadAll() errs = %v, want only error[1]", errs)
+	}
+}
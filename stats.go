@@ -0,0 +1,44 @@
+package dataloaden
+
+import "sync/atomic"
+
+// Stats are counters describing how a Loader has been used
+type Stats struct {
+	// CacheHits is the number of loads served from the cache
+	CacheHits int64
+
+	// CacheMisses is the number of loads that had to wait for a batch
+	CacheMisses int64
+
+	// Batches is the number of batches sent to fetch
+	Batches int64
+
+	// KeysFetched is the total number of keys sent to fetch
+	KeysFetched int64
+}
+
+// loaderStats is updated atomically, so it must stay 64-bit aligned
+type loaderStats struct {
+	cacheHits   int64
+	cacheMisses int64
+	batches     int64
+	keysFetched int64
+}
+
+// Stats returns a snapshot of the counters of the loader
+func (l *Loader[K, V]) Stats() Stats {
+	return Stats{
+		CacheHits:   atomic.LoadInt64(&l.stats.cacheHits),
+		CacheMisses: atomic.LoadInt64(&l.stats.cacheMisses),
+		Batches:     atomic.LoadInt64(&l.stats.batches),
+		KeysFetched: atomic.LoadInt64(&l.stats.keysFetched),
+	}
+}
+
+// reset zeroes the counters
+func (s *loaderStats) reset() {
+	atomic.StoreInt64(&s.cacheHits, 0)
+	atomic.StoreInt64(&s.cacheMisses, 0)
+	atomic.StoreInt64(&s.batches, 0)
+	atomic.StoreInt64(&s.keysFetched, 0)
+}
@@ -0,0 +1,75 @@
+package dataloaden_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Warashi/dataloaden"
+)
+
+func TestLoader_Stats(t *testing.T) {
+	fetch := func(keys []int) ([]int, []error) {
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch: fetch,
+		Wait:  1 * time.Hour,
+	}
+	loader := dataloaden.NewLoader(config)
+	loader.Prime(0, 0)
+
+	// flushing sends each batch only once all of its keys are loaded
+	for _, keys := range [][]int{{0, 1, 2, 2}, {0, 1, 3}} {
+		thunk := loader.LoadAllThunk(keys)
+		loader.Flush()
+		thunk()
+	}
+
+	want := dataloaden.Stats{
+		CacheHits:   3,
+		CacheMisses: 3,
+		Batches:     2,
+		KeysFetched: 3,
+	}
+	if got := loader.Stats(); got != want {
+		t.Errorf("Stats() got = %+v, want %+v", got, want)
+	}
+}
+
+func TestLoader_ActiveBatches(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(keys []int) ([]int, []error) {
+		started <- struct{}{}
+		<-release
+		return make([]int, len(keys)), nil
+	}
+	config := dataloaden.LoaderConfig[int, int]{
+		Fetch:    fetch,
+		Wait:     1 * time.Millisecond,
+		MaxBatch: 1,
+	}
+	loader := dataloaden.NewLoader(config)
+
+	if got := loader.ActiveBatches(); got != 0 {
+		t.Errorf("ActiveBatches() got = %v, want %v", got, 0)
+	}
+	thunks := loader.LoadThunksAll([]int{1, 2})
+	<-started
+	<-started
+	if got := loader.ActiveBatches(); got != 2 {
+		t.Errorf("ActiveBatches() got = %v, want %v", got, 2)
+	}
+	close(release)
+	for _, thunk := range thunks {
+		thunk()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for loader.ActiveBatches() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("ActiveBatches() got = %v after fetching, want %v", loader.ActiveBatches(), 0)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}